@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"wb/internal/generator"
+	"wb/internal/kafka"
+	"wb/pkg/tracing"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	brokers := flag.String("brokers", os.Getenv("KAFKA_BROKERS"), "comma separated kafka brokers")
+	topic := flag.String("topic", os.Getenv("KAFKA_TOPIC"), "kafka topic")
+	rate := flag.Float64("rate", 10, "orders per second, 0 for unlimited")
+	count := flag.Int("count", 0, "total orders to send, 0 for unlimited")
+	concurrency := flag.Int("concurrency", 1, "number of concurrent writers")
+	duration := flag.Duration("duration", 0, "stop after this duration, 0 for unlimited")
+	items := flag.Int("items", 0, "items per order, 0 for a random count")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "random seed")
+	startAt := flag.String("start", "", "date_created of the first order (RFC3339), now if empty; with -seed makes runs repeatable")
+	faults := flag.String("faults", "", "fault shares, e.g. malformed_json=0.1,duplicate=0.05")
+	summaryPath := flag.String("summary", "", "write per-fault JSON summary to this file")
+	flag.Parse()
+
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	sugar := logger.Sugar()
+
+	if *brokers == "" || *topic == "" {
+		sugar.Fatal("brokers and topic are required")
+	}
+	// A faster rate would round the tick interval down to zero.
+	if *rate < 0 || *rate > float64(time.Second) {
+		sugar.Fatalf("rate must be between 0 and %g orders per second", float64(time.Second))
+	}
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+	genStart := time.Now()
+	if *startAt != "" {
+		t, err := time.Parse(time.RFC3339, *startAt)
+		if err != nil {
+			sugar.Fatalf("parse start: %v", err)
+		}
+		genStart = t
+	}
+	mix, err := generator.ParseFaultMix(*faults)
+	if err != nil {
+		sugar.Fatalf("parse faults: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	// The producer puts the trace context into message headers, so consumer
+	// spans can be tied to this run.
+	shutdownTracing, err := tracing.Init(ctx, sugar, "wb-loadgen")
+	if err != nil {
+		sugar.Fatalf("init tracing: %v", err)
+	}
+	defer shutdownTracing(context.WithoutCancel(ctx))
+
+	producer := kafka.NewProducer(strings.Split(*brokers, ","), *topic, sugar)
+	defer producer.Close()
+
+	sugar.Infow("load generation started",
+		"rate", *rate, "count", *count, "concurrency", *concurrency,
+		"duration", *duration, "items", *items, "seed", *seed, "start", genStart, "faults", *faults)
+
+	batches := make(chan []generator.Message)
+	summary := generator.NewFaultSummary()
+	var sent, failed atomic.Int64
+	var wg sync.WaitGroup
+
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				for _, m := range batch {
+					err := producer.ProduceRaw(ctx, m.Key, m.Value)
+					summary.Record(m.Fault, err)
+					if err != nil {
+						failed.Add(1)
+						sugar.Warnw("produce failed", "order_uid", m.OrderUID, "fault", m.Fault, "err", err)
+						continue
+					}
+					sent.Add(1)
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	injector := generator.NewFaultInjector(generator.New(*seed, genStart), *seed, mix)
+	generate(ctx, batches, injector, *rate, *count, *items)
+	close(batches)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sugar.Infow("load generation finished",
+		"sent", sent.Load(),
+		"failed", failed.Load(),
+		"elapsed", elapsed.String(),
+		"throughput_per_sec", float64(sent.Load())/elapsed.Seconds(),
+	)
+	if len(mix) > 0 {
+		fmt.Print(summary)
+	}
+
+	if *summaryPath != "" {
+		b, _ := json.MarshalIndent(summary, "", "  ")
+		if err := os.WriteFile(*summaryPath, b, 0o644); err != nil {
+			sugar.Errorw("write summary failed", "path", *summaryPath, "err", err)
+		}
+	}
+}
+
+func generate(ctx context.Context, out chan<- []generator.Message, injector *generator.FaultInjector, rate float64, count, items int) {
+	var tick <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for n := 0; count == 0 || n < count; n++ {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case out <- injector.Next(items):
+		}
+	}
+}
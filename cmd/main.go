@@ -2,19 +2,24 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"go.uber.org/zap"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 	"wb/internal/cache"
+	"wb/internal/generator"
 	"wb/internal/handler"
 	"wb/internal/kafka"
+	"wb/internal/models"
 	"wb/internal/repository"
 	"wb/internal/service"
 	ui2 "wb/internal/ui"
 	"wb/pkg/postgres"
+	"wb/pkg/tracing"
 )
 
 func main() {
@@ -25,18 +30,51 @@ func main() {
 
 	ctx := context.Background()
 
-	db, err := postgres.InitDB(sugar)
+	shutdownTracing, err := tracing.Init(ctx, sugar, "wb-order-service")
 	if err != nil {
-		sugar.Fatalf("Init db failed: %v", err)
+		sugar.Fatalf("Init tracing failed: %v", err)
 	}
+	defer shutdownTracing(ctx)
 
 	orderCache := cache.NewCache(cacheSize)
-	deliveryRepo := repository.NewDeliveryRepo(db)
-	itemRepo := repository.NewItemRepo(db)
-	paymentRepo := repository.NewPaymentRepo(db)
-	orderRepo := repository.NewOrderRepo(db, deliveryRepo, paymentRepo, itemRepo)
-	orderService := service.NewOrderService(sugar, orderRepo)
+
+	var (
+		orderRepo     orderStore
+		outboxRepo    *repository.OutboxRepo
+		partitionRepo *repository.PartitionRepo
+		rateSource    service.RateSource
+	)
+	if os.Getenv("DEMO_MODE") == "true" {
+		memRepo := repository.NewMemoryOrderRepo()
+		if err := seedDemo(ctx, memRepo, envInt("DEMO_ORDERS", 50)); err != nil {
+			sugar.Fatalf("Seed demo orders failed: %v", err)
+		}
+		sugar.Infow("running in demo mode with in-memory storage")
+		orderRepo = memRepo
+	} else {
+		var rateRepo *repository.RateRepo
+		orderRepo, outboxRepo, partitionRepo, rateRepo = initPostgres(ctx, sugar)
+		rateSource = rateRepo
+	}
+
+	// Rates come from RATES_FILE if set, else from the exchange_rates table.
+	if path := os.Getenv("RATES_FILE"); path != "" {
+		rateSource = repository.NewRateFile(path)
+	}
+	var converter *service.Converter
+	if rateSource != nil {
+		converter = service.NewConverter(sugar, rateSource, orderRepo, orderCache, envOr("REPORTING_CURRENCY", "RUB"))
+	}
+
+	orderService := service.NewOrderService(sugar, orderRepo, converter)
 	orderHandler := handler.NewOrderHandler(sugar, orderService, orderCache)
+	retention := envDuration("ORDER_RETENTION", 30*24*time.Hour)
+	var partitioner *service.Partitioner
+	if partitionRepo != nil {
+		partitioner = service.NewPartitioner(sugar, partitionRepo, envInt("PARTITIONS_AHEAD", 3), envInt("ARCHIVE_AFTER_MONTHS", 0))
+	}
+	adminHandler := handler.NewAdminHandler(sugar, orderService, partitioner, orderCache, retention)
+	purger := service.NewPurger(sugar, orderService, retention)
 	ui := ui2.NewSimpleUi()
 	warmer := cache.NewWarmer(orderRepo, orderCache)
 
@@ -51,26 +89,132 @@ func main() {
 	topic := os.Getenv("KAFKA_TOPIC")
 	group := os.Getenv("KAFKA_GROUP")
 
-	consumer := kafka.NewConsumer(brokers, topic, group, sugar, orderService, orderCache)
+	// Demo mode may run without Kafka at all.
+	kafkaEnabled := os.Getenv("KAFKA_BROKERS") != ""
+
+	if kafkaEnabled {
+		consumer := kafka.NewConsumer(brokers, topic, group, sugar, orderService, orderCache)
+
+		batchSize, _ := strconv.Atoi(os.Getenv("KAFKA_BATCH_SIZE"))
+		go func() {
+			var err error
+			if batchSize > 1 {
+				err = consumer.StartBatch(ctx, batchSize, envDuration("KAFKA_BATCH_WAIT", time.Second))
+			} else {
+				err = consumer.Start(ctx)
+			}
+			if err != nil {
+				sugar.Errorw("kafka consumer stopped with error", "err", err)
+			}
+		}()
+
+		statusConsumer := kafka.NewStatusConsumer(brokers, envOr("KAFKA_STATUS_TOPIC", "order-status"), group, sugar, orderService, orderCache)
+		go func() {
+			if err := statusConsumer.Start(ctx); err != nil {
+				sugar.Errorw("kafka status consumer stopped with error", "err", err)
+			}
+		}()
+	}
+
+	if kafkaEnabled && outboxRepo != nil {
+		relay := kafka.NewRelay(brokers, envOr("ORDER_EVENTS_TOPIC", "order-events"), outboxRepo,
+			envDuration("OUTBOX_RETENTION", 24*time.Hour), sugar)
+		defer relay.Close()
+
+		go func() {
+			if err := relay.Run(ctx, envDuration("OUTBOX_INTERVAL", time.Second)); err != nil {
+				sugar.Errorw("outbox relay stopped with error", "err", err)
+			}
+		}()
+	}
 
 	go func() {
-		if err := consumer.Start(ctx); err != nil {
-			sugar.Errorw("kafka consumer stopped with error", "err", err)
+		if err := purger.Run(ctx, envDuration("PURGE_INTERVAL", time.Hour)); err != nil {
+			sugar.Errorw("order purger stopped with error", "err", err)
 		}
 	}()
 
-	producer := kafka.NewProducer(brokers, topic, sugar)
+	if converter != nil {
+		go func() {
+			if err := converter.Run(ctx, envDuration("RATES_INTERVAL", time.Hour)); err != nil {
+				sugar.Errorw("currency converter stopped with error", "err", err)
+			}
+		}()
+	}
 
-	go func() {
-		for {
-			if err := producer.Run(ctx, time.Second*15); err != nil {
-				sugar.Errorw("failed to produce order", "err", err)
+	if partitioner != nil {
+		go func() {
+			if err := partitioner.Run(ctx, envDuration("PARTITION_INTERVAL", 24*time.Hour)); err != nil {
+				sugar.Errorw("order partitioner stopped with error", "err", err)
 			}
+		}()
+	}
+
+	if kafkaEnabled && os.Getenv("PRODUCER_ENABLED") == "true" {
+		interval := envDuration("PRODUCER_INTERVAL", 15*time.Second)
+		mix, err := generator.ParseFaultMix(os.Getenv("PRODUCER_FAULTS"))
+		if err != nil {
+			sugar.Fatalf("Parse PRODUCER_FAULTS failed: %v", err)
 		}
-	}()
+		producer := kafka.NewProducer(brokers, topic, sugar)
+		defer producer.Close()
+
+		go func() {
+			if err := producer.Run(ctx, interval, mix); err != nil {
+				sugar.Errorw("failed to produce order", "err", err)
+			}
+		}()
+	}
 
 	orderRouter := http.NewServeMux()
 	orderRouter.HandleFunc("GET /order/{order_uid}", orderHandler.GetOrder)
+	orderRouter.HandleFunc("GET /order/{order_uid}/history", orderHandler.GetOrderHistory)
+	orderRouter.HandleFunc("GET /order/{order_uid}/status", orderHandler.GetOrderStatus)
+	orderRouter.HandleFunc("GET /order/{order_uid}/items/status", orderHandler.GetItemStatuses)
+	orderRouter.HandleFunc("GET /orders", orderHandler.ListOrders)
+	orderRouter.HandleFunc("GET /orders/search", orderHandler.SearchOrders)
+	orderRouter.HandleFunc("GET /orders/track/{value}", orderHandler.LookupOrders(models.LookupTrackNumber))
+	orderRouter.HandleFunc("GET /orders/transaction/{value}", orderHandler.LookupOrders(models.LookupTransaction))
+	orderRouter.HandleFunc("GET /orders/chrt/{value}", orderHandler.LookupOrders(models.LookupChrtID))
+	orderRouter.HandleFunc("GET /orders/rid/{value}", orderHandler.LookupOrders(models.LookupRid))
+	orderRouter.HandleFunc("GET /orders/nm/{value}", orderHandler.LookupOrders(models.LookupNmID))
+	orderRouter.HandleFunc("GET /customers/{customer_id}/orders", orderHandler.GetCustomerOrders)
+
+	// Writes require WRITE_TOKEN, or ADMIN_TOKEN without one, as a bearer
+	// token and are not served at all without either.
+	writeToken := envOr("WRITE_TOKEN", os.Getenv("ADMIN_TOKEN"))
+	if writeToken != "" {
+		writes := map[string]http.HandlerFunc{
+			"PUT /order/{order_uid}":               orderHandler.PutOrder,
+			"POST /order/{order_uid}/status":       orderHandler.ChangeOrderStatus,
+			"POST /order/{order_uid}/items/status": orderHandler.ChangeItemStatuses,
+		}
+		for pattern, h := range writes {
+			orderRouter.Handle(pattern, handler.RequireToken(writeToken, h))
+		}
+	} else {
+		sugar.Warnw("WRITE_TOKEN and ADMIN_TOKEN are not set, order writes over HTTP disabled")
+	}
+
+	// Admin routes require ADMIN_TOKEN as a bearer token and are not served
+	// at all without one.
+	adminRouter := http.NewServeMux()
+	adminRouter.HandleFunc("DELETE /admin/orders/{order_uid}", adminHandler.DeleteOrder)
+	adminRouter.HandleFunc("POST /admin/orders/{order_uid}/restore", adminHandler.RestoreOrder)
+	adminRouter.HandleFunc("POST /admin/orders/purge", adminHandler.Purge)
+	if partitioner != nil {
+		adminRouter.HandleFunc("GET /admin/partitions", adminHandler.ListPartitions)
+		adminRouter.HandleFunc("POST /admin/partitions/{name}/archive", adminHandler.ArchivePartition)
+		adminRouter.HandleFunc("POST /admin/partitions/{name}/attach", adminHandler.ReattachPartition)
+	}
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		admin := handler.RequireToken(adminToken, adminRouter)
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+			orderRouter.Handle(method+" /admin/", admin)
+		}
+	} else {
+		sugar.Warnw("ADMIN_TOKEN is not set, admin API disabled")
+	}
 	orderRouter.HandleFunc("GET /", ui.Index)
 	server := http.Server{
 		Addr:    httpAddr,
@@ -80,3 +224,88 @@ func main() {
 	log.Println("server started")
 	server.ListenAndServe()
 }
+
+// orderStore is an order repository that can also warm the cache and
+// backfill reporting amounts.
+type orderStore interface {
+	service.OrderRepo
+	service.ReportingStore
+	cache.OrderSource
+}
+
+func initPostgres(ctx context.Context, sugar *zap.SugaredLogger) (*repository.OrderRepo, *repository.OutboxRepo, *repository.PartitionRepo, *repository.RateRepo) {
+	db, err := postgres.InitDB(sugar)
+	if err != nil {
+		sugar.Fatalf("Init db failed: %v", err)
+	}
+
+	replicaDBs, err := postgres.InitReplicas(sugar)
+	if err != nil {
+		sugar.Fatalf("Init replicas failed: %v", err)
+	}
+	var replicas *postgres.ReplicaSet
+	if len(replicaDBs) > 0 {
+		replicas = postgres.NewReplicaSet(db, replicaDBs, envDuration("PG_REPLICA_MAX_LAG", 5*time.Second), sugar)
+		go func() {
+			if err := replicas.Run(ctx, envDuration("PG_REPLICA_CHECK_INTERVAL", 5*time.Second)); err != nil {
+				sugar.Errorw("replica health checks stopped with error", "err", err)
+			}
+		}()
+	}
+
+	if interval := envDuration("PG_STATS_INTERVAL", time.Minute); interval > 0 {
+		go postgres.Monitor(ctx, sugar, "primary", db, interval)
+		for i, r := range replicaDBs {
+			go postgres.Monitor(ctx, sugar, fmt.Sprintf("replica-%d", i), r, interval)
+		}
+	}
+
+	deliveryRepo := repository.NewDeliveryRepo(db)
+	itemRepo := repository.NewItemRepo(db)
+	paymentRepo := repository.NewPaymentRepo(db)
+	outboxRepo := repository.NewOutboxRepo(db)
+	historyRepo := repository.NewHistoryRepo(db)
+
+	archiveDir := envOr("ARCHIVE_DIR", "archive")
+	if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+		sugar.Fatalf("Create archive dir failed: %v", err)
+	}
+	partitionRepo := repository.NewPartitionRepo(db, itemRepo, archiveDir)
+	orderRepo := repository.NewOrderRepo(db, deliveryRepo, paymentRepo, itemRepo, outboxRepo, historyRepo, partitionRepo, replicas)
+	return orderRepo, outboxRepo, partitionRepo, repository.NewRateRepo(db)
+}
+
+// seedDemo fills the in-memory repository with n generated orders.
+func seedDemo(ctx context.Context, repo *repository.MemoryOrderRepo, n int) error {
+	gen := generator.New(time.Now().UnixNano(), time.Now().Add(-30*24*time.Hour))
+	orders := make([]models.Order, n)
+	for i := range orders {
+		orders[i] = gen.Order(0)
+	}
+	ctx = models.WithAudit(ctx, models.Audit{Actor: "demo", Source: "seed"})
+	_, err := repo.BulkUpsert(ctx, orders)
+	return err
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+func envInt(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"wb/pkg/postgres"
+
+	"go.uber.org/zap"
+)
+
+const usage = `usage: migrate [flags] <command> [args]
+
+Commands:
+  up [version]     apply all (or up to version) pending migrations
+  down [version]   roll back the last (or down to version) migration
+  redo             roll back and re-apply the last migration
+  status           show applied and pending migrations
+  version          print the current schema version
+  verify           fail unless every migration of this build is applied
+  create <name>    add an empty NN_name.sql migration to -dir
+
+Connects to PG_DSN; create does not need a database.
+`
+
+func main() {
+	dir := flag.String("dir", "pkg/postgres/migrations", "migrations directory for create")
+	flag.Usage = func() {
+		_, _ = io.WriteString(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	sugar := logger.Sugar()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	if command == "create" {
+		if len(args) != 1 {
+			sugar.Fatal("create needs a migration name")
+		}
+		path, err := postgres.CreateMigration(*dir, args[0])
+		if err != nil {
+			sugar.Fatalf("create migration: %v", err)
+		}
+		fmt.Println(path)
+		return
+	}
+
+	switch command {
+	case "up", "down", "redo", "status", "version", "verify":
+	default:
+		sugar.Fatalf("unknown command %q", command)
+	}
+	if command == "up" && len(args) > 0 {
+		command = "up-to"
+	}
+	if command == "down" && len(args) > 0 {
+		command = "down-to"
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := postgres.ConnectMaintenance(sugar)
+	if err != nil {
+		sugar.Fatalf("connect: %v", err)
+	}
+	defer db.Close()
+
+	if command == "verify" {
+		var version int64
+		if version, err = postgres.VerifySchema(ctx, db.DB); err == nil {
+			sugar.Infow("schema is up to date", "version", version)
+		}
+	} else {
+		err = postgres.Migrate(ctx, db.DB, command, args...)
+	}
+	if err != nil {
+		sugar.Fatalf("%v", err)
+	}
+}
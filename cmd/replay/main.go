@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"wb/internal/kafka"
+	"wb/internal/models"
+	"wb/internal/repository"
+	"wb/pkg/postgres"
+	"wb/pkg/tracing"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run replays the sources and returns the exit code: non-zero when the
+// replay stopped on an error.
+func run() int {
+	brokers := flag.String("brokers", os.Getenv("KAFKA_BROKERS"), "comma separated kafka brokers")
+	topic := flag.String("topic", os.Getenv("KAFKA_TOPIC"), "kafka topic")
+	rate := flag.Float64("rate", 0, "orders per second, 0 for unlimited")
+	progressPath := flag.String("progress", "", "file to store replay progress in and resume from")
+	direct := flag.Bool("direct", false, "bulk load into Postgres (PG_DSN) instead of publishing to kafka")
+	batch := flag.Int("batch", 500, "orders per bulk write in -direct mode")
+	flag.Usage = func() {
+		_, _ = io.WriteString(flag.CommandLine.Output(),
+			"usage: replay [flags] [file.json|file.ndjson|-]...\nReads stdin when no files are given.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+	sugar := logger.Sugar()
+
+	if !*direct && (*brokers == "" || *topic == "") {
+		sugar.Fatal("brokers and topic are required")
+	}
+	// A faster rate would round the tick interval down to zero.
+	if *rate < 0 || *rate > float64(time.Second) {
+		sugar.Fatalf("rate must be between 0 and %g orders per second", float64(time.Second))
+	}
+
+	sources := flag.Args()
+	if len(sources) == 0 {
+		sources = []string{"-"}
+	}
+
+	prog, err := loadProgress(*progressPath)
+	if err != nil {
+		sugar.Fatalf("load progress: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The producer puts the trace context into message headers, so consumer
+	// spans can be tied to this replay.
+	shutdownTracing, err := tracing.Init(ctx, sugar, "wb-replay")
+	if err != nil {
+		sugar.Fatalf("init tracing: %v", err)
+	}
+	defer shutdownTracing(context.WithoutCancel(ctx))
+
+	r := &replayer{
+		progress: prog,
+		logger:   sugar,
+		batch:    *batch,
+	}
+
+	if *direct {
+		db, err := postgres.InitDB(sugar)
+		if err != nil {
+			sugar.Fatalf("Init db failed: %v", err)
+		}
+		defer db.Close()
+		r.store = repository.NewOrderRepo(db, repository.NewDeliveryRepo(db), repository.NewPaymentRepo(db),
+			repository.NewItemRepo(db), repository.NewOutboxRepo(db), repository.NewHistoryRepo(db), nil, nil)
+	} else {
+		producer := kafka.NewProducer(strings.Split(*brokers, ","), *topic, sugar)
+		defer producer.Close()
+		r.producer = producer
+	}
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		defer ticker.Stop()
+		r.tick = ticker.C
+	}
+
+	code := 0
+	for _, src := range sources {
+		if err := r.replaySource(ctx, src); err != nil {
+			sugar.Errorw("replay stopped", "source", src, "err", err)
+			code = 1
+			break
+		}
+	}
+	if err := prog.save(); err != nil {
+		sugar.Errorw("save progress failed", "err", err)
+		code = 1
+	}
+
+	sugar.Infow("replay finished",
+		"published", r.published,
+		"invalid", r.invalid,
+		"skipped", r.skipped,
+	)
+	return code
+}
+
+type replayer struct {
+	producer *kafka.Producer
+	store    *repository.OrderRepo
+	progress *progress
+	logger   *zap.SugaredLogger
+	tick     <-chan time.Time
+
+	batch       int
+	pending     []models.Order
+	pendingUpTo int
+
+	published int
+	invalid   int
+	skipped   int
+}
+
+func (r *replayer) replaySource(ctx context.Context, src string) error {
+	in := io.Reader(os.Stdin)
+	if src != "-" {
+		f, err := os.Open(src)
+		if err != nil {
+			return errors.WithMessage(err, "open source")
+		}
+		defer f.Close()
+		in = f
+	}
+
+	done := r.progress.Done[src]
+	r.logger.Infow("replaying source", "source", src, "resume_from", done)
+
+	err := decodeOrders(in, func(n int, raw json.RawMessage) error {
+		if n < done {
+			r.skipped++
+			return nil
+		}
+
+		var order models.Order
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		err := models.DecodeOrder(dec, &order)
+		if err == nil {
+			err = order.Validate()
+		}
+		if err != nil {
+			r.invalid++
+			r.logger.Warnw("invalid order skipped", "source", src, "record", n, "err", err)
+			if len(r.pending) > 0 {
+				r.pendingUpTo = n + 1
+				return nil
+			}
+			return r.progress.mark(src, n+1)
+		}
+
+		if r.tick != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-r.tick:
+			}
+		}
+
+		if r.store != nil {
+			r.pending = append(r.pending, order)
+			r.pendingUpTo = n + 1
+			if len(r.pending) < r.batch {
+				return nil
+			}
+			return r.flush(ctx, src)
+		}
+
+		if err := r.producer.Produce(ctx, order); err != nil {
+			return errors.WithMessagef(err, "publish record %d (order_uid %s)", n, order.OrderUID)
+		}
+		r.published++
+		return r.progress.mark(src, n+1)
+	})
+	if err != nil {
+		return err
+	}
+	return r.flush(ctx, src)
+}
+
+// flush bulk writes pending orders and only then advances the progress marker.
+func (r *replayer) flush(ctx context.Context, src string) error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	n, err := r.store.BulkUpsert(models.WithAudit(ctx, models.Audit{Actor: "replay", Source: src}), r.pending)
+	if err != nil {
+		return errors.WithMessagef(err, "bulk write records before %d", r.pendingUpTo)
+	}
+	r.published += n
+	r.pending = r.pending[:0]
+	return r.progress.mark(src, r.pendingUpTo)
+}
+
+// decodeOrders calls fn for every order in a JSON array, a single JSON
+// object or a stream of concatenated/newline delimited objects.
+func decodeOrders(in io.Reader, fn func(n int, raw json.RawMessage) error) error {
+	br := bufio.NewReader(in)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return errors.WithMessage(err, "read input")
+	}
+
+	dec := json.NewDecoder(br)
+	if first == '[' {
+		if _, err := dec.Token(); err != nil {
+			return errors.WithMessage(err, "read array start")
+		}
+	}
+
+	for n := 0; dec.More(); n++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return errors.WithMessagef(err, "decode record %d", n)
+		}
+		if err := fn(n, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// The progress file is rewritten at most every progressEvery records or
+// progressInterval, and when replay ends; after a crash up to that many
+// records are replayed again, which the idempotent upserts absorb.
+const (
+	progressEvery    = 1000
+	progressInterval = time.Second
+)
+
+type progress struct {
+	path string
+	Done map[string]int `json:"done"`
+
+	unsaved int
+	savedAt time.Time
+}
+
+func loadProgress(path string) (*progress, error) {
+	p := &progress{path: path, Done: map[string]int{}}
+	if path == "" {
+		return p, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, errors.WithMessage(err, "parse progress file")
+	}
+	if p.Done == nil {
+		p.Done = map[string]int{}
+	}
+	return p, nil
+}
+
+func (p *progress) mark(src string, done int) error {
+	p.unsaved += done - p.Done[src]
+	p.Done[src] = done
+	if p.unsaved < progressEvery && time.Since(p.savedAt) < progressInterval {
+		return nil
+	}
+	return p.save()
+}
+
+// save writes the progress file if anything changed since the last write.
+func (p *progress) save() error {
+	if p.path == "" || p.unsaved == 0 {
+		return nil
+	}
+	p.unsaved, p.savedAt = 0, time.Now()
+
+	b, _ := json.Marshal(p)
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return errors.WithMessage(err, "write progress")
+	}
+	return errors.WithMessage(os.Rename(tmp, p.path), "write progress")
+}
@@ -2,24 +2,34 @@ package cache
 
 import (
 	"fmt"
+	"sync"
 	"wb/internal/models"
 )
 
 type Cache struct {
+	mu       sync.RWMutex
 	cacheMap map[string]models.Order
 	keys     []string
 	size     int
+
+	customerMap  map[string]models.CustomerHistory
+	customerKeys []string
 }
 
 func NewCache(size int) *Cache {
 	return &Cache{
-		cacheMap: make(map[string]models.Order),
-		keys:     make([]string, 0, size),
-		size:     size,
+		cacheMap:     make(map[string]models.Order),
+		keys:         make([]string, 0, size),
+		size:         size,
+		customerMap:  make(map[string]models.CustomerHistory),
+		customerKeys: make([]string, 0, size),
 	}
 }
 
 func (c *Cache) PutInCache(key string, order models.Order) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if _, ok := c.cacheMap[key]; ok {
 		c.cacheMap[key] = order
 		return
@@ -36,10 +46,76 @@ func (c *Cache) PutInCache(key string, order models.Order) {
 }
 
 func (c *Cache) GetIfInCache(key string) (models.Order, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	order, ok := c.cacheMap[key]
 	return order, ok
 }
 
+func (c *Cache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.cacheMap[key]; !ok {
+		return
+	}
+	delete(c.cacheMap, key)
+	for i, k := range c.keys {
+		if k == key {
+			c.keys = append(c.keys[:i], c.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+func (c *Cache) PutCustomerHistory(customerID string, h models.CustomerHistory) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.customerMap[customerID]; ok {
+		c.customerMap[customerID] = h
+		return
+	}
+
+	if len(c.customerKeys) >= c.size {
+		oldest := c.customerKeys[0]
+		c.customerKeys = c.customerKeys[1:]
+		delete(c.customerMap, oldest)
+	}
+
+	c.customerMap[customerID] = h
+	c.customerKeys = append(c.customerKeys, customerID)
+}
+
+func (c *Cache) GetCustomerHistory(customerID string) (models.CustomerHistory, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	h, ok := c.customerMap[customerID]
+	return h, ok
+}
+
+// InvalidateCustomer drops a cached history after one of the customer's orders changed.
+func (c *Cache) InvalidateCustomer(customerID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.customerMap[customerID]; !ok {
+		return
+	}
+	delete(c.customerMap, customerID)
+	for i, k := range c.customerKeys {
+		if k == customerID {
+			c.customerKeys = append(c.customerKeys[:i], c.customerKeys[i+1:]...)
+			break
+		}
+	}
+}
+
 func (c *Cache) Show() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	fmt.Println(c.keys)
 }
@@ -3,15 +3,19 @@ package cache
 import (
 	"context"
 	"github.com/pkg/errors"
-	"wb/internal/repository"
+	"wb/internal/models"
 )
 
+type OrderSource interface {
+	GetLastOrders(ctx context.Context, n int) ([]models.Order, error)
+}
+
 type Warmer struct {
 	cache     *Cache
-	orderRepo *repository.OrderRepo
+	orderRepo OrderSource
 }
 
-func NewWarmer(orderRepo *repository.OrderRepo, cache *Cache) *Warmer {
+func NewWarmer(orderRepo OrderSource, cache *Cache) *Warmer {
 	return &Warmer{
 		cache:     cache,
 		orderRepo: orderRepo,
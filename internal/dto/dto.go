@@ -17,6 +17,43 @@ type OrderResponse struct {
 	SmId              int              `json:"sm_id"`
 	DateCreated       time.Time        `json:"date_created"`
 	OofShard          string           `json:"oof_shard"`
+	Status            string           `json:"status"`
+}
+
+type OrderListResponse struct {
+	Orders     []OrderResponse `json:"orders"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type OrderSearchResponse struct {
+	Query   string              `json:"query"`
+	Results []OrderSearchResult `json:"results"`
+}
+
+type OrderSearchResult struct {
+	Order      OrderResponse `json:"order"`
+	Rank       float64       `json:"rank"`
+	Highlights []string      `json:"highlights,omitempty"`
+}
+
+type CustomerOrdersResponse struct {
+	CustomerId string  `json:"customer_id"`
+	OrderCount int     `json:"order_count"`
+	TotalSpent []Money `json:"total_spent"`
+	// TotalReporting sums the converted order amounts per reporting currency.
+	TotalReporting []Money         `json:"total_spent_reporting"`
+	FirstOrderAt   time.Time       `json:"first_order_at"`
+	LastOrderAt    time.Time       `json:"last_order_at"`
+	Orders         []OrderResponse `json:"orders"`
+}
+
+type OrderVersionResponse struct {
+	Version    int            `json:"version"`
+	Operation  string         `json:"operation"`
+	RecordedAt time.Time      `json:"recorded_at"`
+	Source     string         `json:"source"`
+	Actor      string         `json:"actor"`
+	Order      *OrderResponse `json:"order,omitempty"`
 }
 
 type DeliveryResponse struct {
@@ -34,24 +71,94 @@ type PaymentResponse struct {
 	RequestId    string `json:"request_id"`
 	Currency     string `json:"currency"`
 	Provider     string `json:"provider"`
-	Amount       int    `json:"amount"`
+	Amount       Money  `json:"amount"`
 	PaymentDt    int64  `json:"payment_dt"`
 	Bank         string `json:"bank"`
-	DeliveryCost int    `json:"delivery_cost"`
-	GoodsTotal   int    `json:"goods_total"`
-	CustomFee    int    `json:"custom_fee"`
+	DeliveryCost Money  `json:"delivery_cost"`
+	GoodsTotal   Money  `json:"goods_total"`
+	CustomFee    Money  `json:"custom_fee"`
+	// Reporting is Amount in the reporting currency at payment_dt, absent
+	// while no exchange rate for that date is known.
+	Reporting *Money `json:"reporting_amount,omitempty"`
+}
+
+// Money is an amount in minor units of Currency, with Formatted giving it in
+// major units as "18.17 USD".
+type Money struct {
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+	Formatted string `json:"formatted"`
 }
 
 type ItemResponse struct {
 	ChrtId      int    `json:"chrt_id"`
 	TrackNumber string `json:"track_number"`
-	Price       int    `json:"price"`
+	Price       Money  `json:"price"`
 	Rid         string `json:"rid"`
 	Name        string `json:"name"`
 	Sale        int    `json:"sale"`
 	Size        string `json:"size"`
-	TotalPrice  int    `json:"total_price"`
+	TotalPrice  Money  `json:"total_price"`
 	NmId        int    `json:"nm_id"`
 	Brand       string `json:"brand"`
 	Status      int    `json:"status"`
+
+	FulfillmentStatus string    `json:"fulfillment_status"`
+	StatusChangedAt   time.Time `json:"status_changed_at"`
+}
+
+type PartitionResponse struct {
+	Name       string     `json:"name"`
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+	Orders     int64      `json:"orders"`
+	Archived   bool       `json:"archived"`
+	Path       string     `json:"path,omitempty"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+}
+
+type OrderStatusResponse struct {
+	OrderUID string                 `json:"order_uid"`
+	Status   string                 `json:"status"`
+	Next     []string               `json:"next"`
+	Items    map[string]int         `json:"items,omitempty"`
+	History  []StatusRecordResponse `json:"history"`
+}
+
+type ItemStatusesResponse struct {
+	OrderUID string               `json:"order_uid"`
+	Status   string               `json:"status"`
+	Items    []ItemStatusResponse `json:"items"`
+}
+
+type ItemStatusResponse struct {
+	ChrtId    int                    `json:"chrt_id"`
+	Rid       string                 `json:"rid"`
+	Name      string                 `json:"name"`
+	Status    string                 `json:"status"`
+	ChangedAt time.Time              `json:"changed_at"`
+	Next      []string               `json:"next"`
+	History   []StatusRecordResponse `json:"history"`
+}
+
+type StatusRecordResponse struct {
+	From       string    `json:"from,omitempty"`
+	Status     string    `json:"status"`
+	Reason     string    `json:"reason,omitempty"`
+	ChangedAt  time.Time `json:"changed_at"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Source     string    `json:"source"`
+	Actor      string    `json:"actor"`
+}
+
+type ItemRef struct {
+	ChrtId int    `json:"chrt_id"`
+	Rid    string `json:"rid"`
+}
+
+type StatusChangeRequest struct {
+	Items     []ItemRef  `json:"items"`
+	Status    string     `json:"status"`
+	Reason    string     `json:"reason"`
+	ChangedAt *time.Time `json:"changed_at"`
 }
@@ -0,0 +1,213 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Fault string
+
+const (
+	FaultNone          Fault = "valid"
+	FaultMalformedJSON Fault = "malformed_json"
+	FaultMissingUID    Fault = "missing_order_uid"
+	FaultDuplicate     Fault = "duplicate"
+	FaultOutOfOrder    Fault = "out_of_order"
+	FaultOversized     Fault = "oversized"
+	FaultWrongTypes    Fault = "wrong_types"
+	FaultTombstone     Fault = "tombstone"
+)
+
+var Faults = []Fault{
+	FaultMalformedJSON, FaultMissingUID, FaultDuplicate, FaultOutOfOrder,
+	FaultOversized, FaultWrongTypes, FaultTombstone,
+}
+
+// oversizedPayload makes a message larger than the 1e6 bytes the consumer
+// fetches by default (kafka-go ReaderConfig.MaxBytes) while keeping it under
+// the writer's 1 MiB BatchBytes, so the producer still sends it.
+const oversizedPayload = 1_010_000
+
+// Message is a raw kafka payload; Value is nil for tombstones.
+type Message struct {
+	Key      []byte
+	Value    []byte
+	OrderUID string
+	Fault    Fault
+}
+
+// FaultMix maps a fault to the share of generated batches that carry it;
+// the remainder is valid orders.
+type FaultMix map[Fault]float64
+
+// ParseFaultMix parses "malformed_json=0.1,duplicate=0.05".
+func ParseFaultMix(s string) (FaultMix, error) {
+	mix := FaultMix{}
+	if strings.TrimSpace(s) == "" {
+		return mix, nil
+	}
+
+	total := 0.0
+	for _, part := range strings.Split(s, ",") {
+		name, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, errors.Errorf("bad fault %q, want name=share", part)
+		}
+		f := Fault(name)
+		if !knownFault(f) {
+			return nil, errors.Errorf("unknown fault %q", name)
+		}
+		share, err := strconv.ParseFloat(val, 64)
+		if err != nil || share < 0 {
+			return nil, errors.Errorf("bad share for fault %q: %q", name, val)
+		}
+		mix[f] = share
+		total += share
+	}
+	if total > 1 {
+		return nil, errors.Errorf("fault shares add up to %.2f, want <= 1", total)
+	}
+	return mix, nil
+}
+
+func knownFault(f Fault) bool {
+	for _, known := range Faults {
+		if f == known {
+			return true
+		}
+	}
+	return false
+}
+
+type FaultInjector struct {
+	gen *Generator
+	rng *rand.Rand
+	mix FaultMix
+}
+
+func NewFaultInjector(gen *Generator, seed int64, mix FaultMix) *FaultInjector {
+	return &FaultInjector{
+		gen: gen,
+		rng: rand.New(rand.NewSource(seed)),
+		mix: mix,
+	}
+}
+
+// Next returns the messages for one generated order. Duplicate and
+// out-of-order faults yield two messages with the same key.
+func (f *FaultInjector) Next(items int) []Message {
+	order := f.gen.Order(items)
+	valid, _ := json.Marshal(order)
+	key := []byte(order.OrderUID)
+	msg := func(fault Fault, value []byte) Message {
+		return Message{Key: key, Value: value, OrderUID: order.OrderUID, Fault: fault}
+	}
+
+	switch f.pick() {
+	case FaultMalformedJSON:
+		return []Message{msg(FaultMalformedJSON, valid[:len(valid)/2])}
+
+	case FaultMissingUID:
+		order.OrderUID = ""
+		b, _ := json.Marshal(order)
+		return []Message{msg(FaultMissingUID, b)}
+
+	case FaultDuplicate:
+		return []Message{msg(FaultNone, valid), msg(FaultDuplicate, valid)}
+
+	case FaultOutOfOrder:
+		older := order
+		older.DateCreated = order.DateCreated.Add(-time.Hour)
+		older.TrackNumber = order.TrackNumber + "-OLD"
+		stale, _ := json.Marshal(older)
+		return []Message{msg(FaultNone, valid), msg(FaultOutOfOrder, stale)}
+
+	case FaultOversized:
+		order.InternalSignature = strings.Repeat("x", oversizedPayload)
+		b, _ := json.Marshal(order)
+		return []Message{msg(FaultOversized, b)}
+
+	case FaultWrongTypes:
+		var raw map[string]any
+		_ = json.Unmarshal(valid, &raw)
+		raw["sm_id"] = fmt.Sprint(order.SmId)
+		raw["date_created"] = order.DateCreated.Unix()
+		if p, ok := raw["payment"].(map[string]any); ok {
+			p["amount"] = fmt.Sprint(order.Payment.Amount.Amount)
+		}
+		b, _ := json.Marshal(raw)
+		return []Message{msg(FaultWrongTypes, b)}
+
+	case FaultTombstone:
+		return []Message{msg(FaultTombstone, nil)}
+	}
+
+	return []Message{msg(FaultNone, valid)}
+}
+
+func (f *FaultInjector) pick() Fault {
+	roll := f.rng.Float64()
+	for _, fault := range Faults {
+		share := f.mix[fault]
+		if roll < share {
+			return fault
+		}
+		roll -= share
+	}
+	return FaultNone
+}
+
+// FaultSummary counts what was sent per fault, safe for concurrent use.
+type FaultSummary struct {
+	mu     sync.Mutex
+	Sent   map[Fault]int64 `json:"sent"`
+	Failed map[Fault]int64 `json:"failed"`
+}
+
+func NewFaultSummary() *FaultSummary {
+	return &FaultSummary{
+		Sent:   map[Fault]int64{},
+		Failed: map[Fault]int64{},
+	}
+}
+
+func (s *FaultSummary) Record(f Fault, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.Failed[f]++
+		return
+	}
+	s.Sent[f]++
+}
+
+func (s *FaultSummary) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	faults := make([]string, 0, len(s.Sent)+len(s.Failed))
+	seen := map[Fault]bool{}
+	for _, m := range []map[Fault]int64{s.Sent, s.Failed} {
+		for f := range m {
+			if !seen[f] {
+				seen[f] = true
+				faults = append(faults, string(f))
+			}
+		}
+	}
+	sort.Strings(faults)
+
+	var b strings.Builder
+	for _, f := range faults {
+		fmt.Fprintf(&b, "%s: sent=%d failed=%d\n", f, s.Sent[Fault(f)], s.Failed[Fault(f)])
+	}
+	return b.String()
+}
@@ -0,0 +1,134 @@
+package generator
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFaultMix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    FaultMix
+		wantErr string
+	}{
+		{in: "", want: FaultMix{}},
+		{in: "  ", want: FaultMix{}},
+		{in: "malformed_json=0.1", want: FaultMix{FaultMalformedJSON: 0.1}},
+		{in: " duplicate=0.05, tombstone=0 ", want: FaultMix{FaultDuplicate: 0.05, FaultTombstone: 0}},
+		{in: "oversized=0.5,wrong_types=0.5", want: FaultMix{FaultOversized: 0.5, FaultWrongTypes: 0.5}},
+		{in: "duplicate", wantErr: "want name=share"},
+		{in: "malformed_json=0.1,", wantErr: "want name=share"},
+		{in: "valid=0.1", wantErr: "unknown fault"},
+		{in: "slow=0.1", wantErr: "unknown fault"},
+		{in: "duplicate=abc", wantErr: "bad share"},
+		{in: "duplicate=-0.1", wantErr: "bad share"},
+		{in: "duplicate=0.6,tombstone=0.6", wantErr: "add up to 1.20"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := ParseFaultMix(tc.in)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("mix = %v, want %v", got, tc.want)
+			}
+			for f, share := range tc.want {
+				if s, ok := got[f]; !ok || s != share {
+					t.Fatalf("mix = %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFaultInjectorDistribution(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	mix := FaultMix{FaultMalformedJSON: 0.2, FaultDuplicate: 0.1, FaultTombstone: 0.05}
+	const n = 5000
+
+	counts := map[Fault]int{}
+	inj := NewFaultInjector(New(7, start), 7, mix)
+	for i := 0; i < n; i++ {
+		msgs := inj.Next(1)
+		counts[msgs[len(msgs)-1].Fault]++
+	}
+
+	want := map[Fault]float64{FaultNone: 0.65}
+	for f, share := range mix {
+		want[f] = share
+	}
+	for f, share := range want {
+		if got := float64(counts[f]) / n; math.Abs(got-share) > 0.02 {
+			t.Errorf("%s share = %.3f, want %.2f", f, got, share)
+		}
+	}
+	if len(counts) != len(want) {
+		t.Errorf("faults = %v, want only %v", counts, want)
+	}
+
+	// The same seeds repeat the same faults.
+	again := NewFaultInjector(New(7, start), 7, mix)
+	first := NewFaultInjector(New(7, start), 7, mix)
+	for i := 0; i < 100; i++ {
+		a, b := first.Next(1), again.Next(1)
+		if len(a) != len(b) || a[0].Fault != b[0].Fault || a[0].OrderUID != b[0].OrderUID {
+			t.Fatalf("message %d differs for the same seeds: %v, %v", i, a[0].Fault, b[0].Fault)
+		}
+	}
+}
+
+func TestFaultInjectorMessages(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	next := func(f Fault) []Message {
+		return NewFaultInjector(New(1, start), 1, FaultMix{f: 1}).Next(1)
+	}
+
+	for _, f := range []Fault{FaultDuplicate, FaultOutOfOrder} {
+		msgs := next(f)
+		if len(msgs) != 2 || string(msgs[0].Key) != string(msgs[1].Key) || msgs[0].Fault != FaultNone || msgs[1].Fault != f {
+			t.Errorf("%s: want a valid message and a faulty one with the same key, got %+v", f, msgs)
+		}
+	}
+	if msgs := next(FaultTombstone); msgs[0].Value != nil {
+		t.Errorf("tombstone has value %q", msgs[0].Value)
+	}
+	if msgs := next(FaultMalformedJSON); json.Valid(msgs[0].Value) {
+		t.Errorf("malformed_json is valid JSON: %s", msgs[0].Value)
+	}
+
+	// Larger than the 1e6 bytes a kafka-go reader fetches by default, but
+	// within the writer's default 1 MiB batch and the broker's default
+	// message.max.bytes of 1048588, so it is sent but cannot be consumed.
+	oversized := next(FaultOversized)[0]
+	if size := len(oversized.Key) + len(oversized.Value); size <= 1e6 || size >= 1<<20 {
+		t.Errorf("oversized message is %d bytes, want between 1e6 and 1 MiB", size)
+	}
+}
+
+func TestFaultSummary(t *testing.T) {
+	s := NewFaultSummary()
+	s.Record(FaultNone, nil)
+	s.Record(FaultNone, nil)
+	s.Record(FaultDuplicate, nil)
+	s.Record(FaultOversized, errors.New("write failed"))
+
+	want := "duplicate: sent=1 failed=0\noversized: sent=0 failed=1\nvalid: sent=2 failed=0\n"
+	if got := s.String(); got != want {
+		t.Fatalf("summary =\n%s\nwant\n%s", got, want)
+	}
+	b, err := json.Marshal(s)
+	if err != nil || string(b) != `{"sent":{"duplicate":1,"valid":2},"failed":{"oversized":1}}` {
+		t.Fatalf("summary json = %s, %v", b, err)
+	}
+}
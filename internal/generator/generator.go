@@ -0,0 +1,232 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+	"wb/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const maxRandomItems = 5
+
+type locale struct {
+	code      string
+	currency  string
+	phoneCode string
+	names     []string
+	cities    []city
+	streets   []string
+	services  []string
+	banks     []string
+	providers []string
+	priceMul  int
+}
+
+type city struct {
+	name   string
+	region string
+	zip    string
+}
+
+type product struct {
+	name  string
+	brand string
+	nmID  int
+	price int
+	sizes []string
+}
+
+var locales = []locale{
+	{
+		code:      "ru",
+		currency:  "RUB",
+		phoneCode: "+7",
+		names:     []string{"Ivan Petrov", "Anna Smirnova", "Dmitry Volkov", "Olga Kuznetsova", "Sergey Popov"},
+		cities: []city{
+			{name: "Moscow", region: "Moscow", zip: "101000"},
+			{name: "Saint Petersburg", region: "Leningrad Oblast", zip: "190000"},
+			{name: "Kazan", region: "Tatarstan", zip: "420000"},
+			{name: "Novosibirsk", region: "Novosibirsk Oblast", zip: "630000"},
+		},
+		streets:   []string{"Lenina", "Tverskaya", "Nevsky prospekt", "Sadovaya", "Mira"},
+		services:  []string{"wb", "cdek", "boxberry", "pochta"},
+		banks:     []string{"sber", "tinkoff", "alpha", "vtb"},
+		providers: []string{"wbpay", "sbp", "card"},
+		priceMul:  100,
+	},
+	{
+		code:      "en",
+		currency:  "USD",
+		phoneCode: "+1",
+		names:     []string{"John Smith", "Emily Johnson", "Michael Brown", "Sarah Davis"},
+		cities: []city{
+			{name: "New York", region: "NY", zip: "10001"},
+			{name: "Chicago", region: "IL", zip: "60601"},
+			{name: "Austin", region: "TX", zip: "73301"},
+		},
+		streets:   []string{"Main St", "Oak Ave", "Maple Dr", "Broadway"},
+		services:  []string{"ups", "fedex", "usps"},
+		banks:     []string{"chase", "citi", "wells"},
+		providers: []string{"wbpay", "paypal", "card"},
+		priceMul:  1,
+	},
+	{
+		code:      "he",
+		currency:  "ILS",
+		phoneCode: "+972",
+		names:     []string{"Test Testov", "Noa Levi", "David Cohen", "Yael Mizrahi"},
+		cities: []city{
+			{name: "Kiryat Mozkin", region: "Kraiot", zip: "2639809"},
+			{name: "Haifa", region: "Haifa", zip: "3100000"},
+			{name: "Tel Aviv", region: "Tel Aviv", zip: "6100000"},
+		},
+		streets:   []string{"Ploshad Mira", "Herzl", "Dizengoff", "Ben Yehuda"},
+		services:  []string{"meest", "israel post"},
+		banks:     []string{"leumi", "hapoalim", "alpha"},
+		providers: []string{"wbpay", "card"},
+		priceMul:  4,
+	},
+	{
+		code:      "kk",
+		currency:  "KZT",
+		phoneCode: "+7",
+		names:     []string{"Aidar Nurlanov", "Aigerim Sadykova", "Timur Akhmetov"},
+		cities: []city{
+			{name: "Almaty", region: "Almaty", zip: "050000"},
+			{name: "Astana", region: "Astana", zip: "010000"},
+		},
+		streets:   []string{"Abaya", "Dostyk", "Satpaeva"},
+		services:  []string{"wb", "kazpost"},
+		banks:     []string{"kaspi", "halyk"},
+		providers: []string{"wbpay", "kaspi"},
+		priceMul:  500,
+	},
+}
+
+var catalog = []product{
+	{name: "Mascaras", brand: "Vivienne Sabo", nmID: 2389212, price: 453, sizes: []string{"0"}},
+	{name: "Lipstick", brand: "Maybelline", nmID: 2389301, price: 399, sizes: []string{"0"}},
+	{name: "T-shirt", brand: "Befree", nmID: 1174522, price: 1299, sizes: []string{"S", "M", "L", "XL"}},
+	{name: "Jeans", brand: "Gloria Jeans", nmID: 1174610, price: 2499, sizes: []string{"28", "30", "32", "34"}},
+	{name: "Sneakers", brand: "Demix", nmID: 3302718, price: 3999, sizes: []string{"39", "40", "41", "42", "43"}},
+	{name: "Backpack", brand: "Xiaomi", nmID: 4410293, price: 2199, sizes: []string{"0"}},
+	{name: "Headphones", brand: "JBL", nmID: 5520117, price: 4590, sizes: []string{"0"}},
+	{name: "Phone case", brand: "Spigen", nmID: 5520480, price: 799, sizes: []string{"0"}},
+	{name: "Coffee beans", brand: "Lavazza", nmID: 6630021, price: 1190, sizes: []string{"0"}},
+	{name: "Notebook", brand: "Hatber", nmID: 7740315, price: 149, sizes: []string{"A5", "A4"}},
+}
+
+type Generator struct {
+	rng       *rand.Rand
+	clock     time.Time
+	customers int
+}
+
+// New returns a generator whose output is fully determined by seed and start:
+// order dates advance from start by a few random seconds per order.
+func New(seed int64, start time.Time) *Generator {
+	return &Generator{
+		rng:       rand.New(rand.NewSource(seed)),
+		clock:     start.UTC(),
+		customers: 1000,
+	}
+}
+
+// Order builds the next order with the given number of items,
+// or a random number of items (1..5) when items <= 0.
+func (g *Generator) Order(items int) models.Order {
+	if items <= 0 {
+		items = 1 + g.rng.Intn(maxRandomItems)
+	}
+
+	g.clock = g.clock.Add(time.Duration(1+g.rng.Intn(30)) * time.Second)
+	loc := locales[g.rng.Intn(len(locales))]
+	c := pick(g.rng, loc.cities)
+	name := pick(g.rng, loc.names)
+
+	orderUID := g.hex(19)
+	track := "WBILM" + strings.ToUpper(g.hex(9))
+
+	o := models.Order{
+		OrderUID:    orderUID,
+		TrackNumber: track,
+		Entry:       "WBIL",
+		Delivery: models.Delivery{
+			Name:    name,
+			Phone:   fmt.Sprintf("%s%010d", loc.phoneCode, g.rng.Int63n(10_000_000_000)),
+			Zip:     c.zip,
+			City:    c.name,
+			Address: fmt.Sprintf("%s %d", pick(g.rng, loc.streets), 1+g.rng.Intn(200)),
+			Region:  c.region,
+			Email:   strings.ToLower(strings.ReplaceAll(name, " ", ".")) + "@example.com",
+		},
+		Locale:            loc.code,
+		InternalSignature: "",
+		CustomerId:        fmt.Sprintf("cust-%04d", g.rng.Intn(g.customers)),
+		DeliveryService:   pick(g.rng, loc.services),
+		ShardKey:          fmt.Sprint(g.rng.Intn(10)),
+		SmId:              1 + g.rng.Intn(100),
+		DateCreated:       g.clock,
+		OofShard:          fmt.Sprint(1 + g.rng.Intn(2)),
+	}
+
+	var goodsTotal int64
+	for i := 0; i < items; i++ {
+		p := catalog[g.rng.Intn(len(catalog))]
+		price := int64(p.price * loc.priceMul)
+		sale := []int{0, 0, 10, 15, 20, 30, 50}[g.rng.Intn(7)]
+		total := price * int64(100-sale) / 100
+		goodsTotal += total
+
+		o.Items = append(o.Items, models.Item{
+			ChrtId:      1_000_000 + g.rng.Intn(9_000_000),
+			TrackNumber: track,
+			Price:       models.Money{Amount: price, Currency: loc.currency},
+			Rid:         g.hex(17) + "test",
+			Name:        p.name,
+			Sale:        sale,
+			Size:        pick(g.rng, p.sizes),
+			TotalPrice:  models.Money{Amount: total, Currency: loc.currency},
+			NmId:        p.nmID,
+			Brand:       p.brand,
+			Status:      202,
+		})
+	}
+
+	deliveryCost := int64((100 + g.rng.Intn(1400)) * loc.priceMul)
+	var customFee int64
+	if g.rng.Intn(5) == 0 {
+		customFee = goodsTotal / 20
+	}
+
+	o.Payment = models.Payment{
+		Transaction:  orderUID,
+		RequestId:    "",
+		Currency:     loc.currency,
+		Provider:     pick(g.rng, loc.providers),
+		Amount:       models.Money{Amount: goodsTotal + deliveryCost + customFee, Currency: loc.currency},
+		PaymentDt:    g.clock.Unix(),
+		Bank:         pick(g.rng, loc.banks),
+		DeliveryCost: models.Money{Amount: deliveryCost, Currency: loc.currency},
+		GoodsTotal:   models.Money{Amount: goodsTotal, Currency: loc.currency},
+		CustomFee:    models.Money{Amount: customFee, Currency: loc.currency},
+	}
+
+	return o
+}
+
+func (g *Generator) hex(n int) string {
+	id, _ := uuid.NewRandomFromReader(g.rng)
+	s := strings.ReplaceAll(id.String(), "-", "")
+	for len(s) < n {
+		s += s
+	}
+	return s[:n]
+}
+
+func pick[T any](rng *rand.Rand, from []T) T {
+	return from[rng.Intn(len(from))]
+}
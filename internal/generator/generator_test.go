@@ -0,0 +1,110 @@
+package generator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"wb/internal/models"
+)
+
+func TestOrderIsDeterministic(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	a, b := New(42, start), New(42, start)
+
+	for i := 0; i < 20; i++ {
+		oa, ob := a.Order(0), b.Order(0)
+		if !reflect.DeepEqual(oa, ob) {
+			t.Fatalf("order %d differs for the same seed and start:\n%+v\n%+v", i, oa, ob)
+		}
+		if !oa.DateCreated.After(start) {
+			t.Fatalf("order %d date_created %v is not after start %v", i, oa.DateCreated, start)
+		}
+	}
+}
+
+func TestOrderDependsOnSeed(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	if a, b := New(1, start).Order(0), New(2, start).Order(0); a.OrderUID == b.OrderUID {
+		t.Fatalf("different seeds produced the same order_uid %s", a.OrderUID)
+	}
+}
+
+func TestOrderIsConsistent(t *testing.T) {
+	byCode := map[string]locale{}
+	for _, l := range locales {
+		byCode[l.code] = l
+	}
+	seen := map[string]bool{}
+
+	g := New(3, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	for i := 0; i < 200; i++ {
+		o := g.Order(0)
+		if err := o.Validate(); err != nil {
+			t.Fatalf("order %d is invalid: %v", i, err)
+		}
+
+		p := o.Payment
+		var goods int64
+		for _, it := range o.Items {
+			goods += it.TotalPrice.Amount
+			if it.TotalPrice.Amount != it.Price.Amount*int64(100-it.Sale)/100 {
+				t.Fatalf("order %d item %s: total %d is not price %d less %d%%", i, it.Rid, it.TotalPrice.Amount, it.Price.Amount, it.Sale)
+			}
+			if it.TrackNumber != o.TrackNumber {
+				t.Fatalf("order %d item track %s, want %s", i, it.TrackNumber, o.TrackNumber)
+			}
+		}
+		if goods != p.GoodsTotal.Amount {
+			t.Fatalf("order %d: items add up to %d, goods_total is %d", i, goods, p.GoodsTotal.Amount)
+		}
+		if sum := p.GoodsTotal.Amount + p.DeliveryCost.Amount + p.CustomFee.Amount; sum != p.Amount.Amount {
+			t.Fatalf("order %d: goods %d + delivery %d + fee %d = %d, amount is %d",
+				i, p.GoodsTotal.Amount, p.DeliveryCost.Amount, p.CustomFee.Amount, sum, p.Amount.Amount)
+		}
+
+		l, ok := byCode[o.Locale]
+		if !ok {
+			t.Fatalf("order %d has unknown locale %q", i, o.Locale)
+		}
+		seen[o.Locale] = true
+		if p.Currency != l.currency {
+			t.Fatalf("order %d in locale %s pays in %s, want %s", i, o.Locale, p.Currency, l.currency)
+		}
+		for _, m := range []models.Money{p.Amount, p.DeliveryCost, p.GoodsTotal, p.CustomFee, o.Items[0].Price} {
+			if m.Currency != l.currency {
+				t.Fatalf("order %d in locale %s has an amount in %s", i, o.Locale, m.Currency)
+			}
+		}
+		if !strings.HasPrefix(o.Delivery.Phone, l.phoneCode) {
+			t.Fatalf("order %d in locale %s has phone %s", i, o.Locale, o.Delivery.Phone)
+		}
+		if !hasCity(l, o.Delivery.City, o.Delivery.Region, o.Delivery.Zip) {
+			t.Fatalf("order %d in locale %s is delivered to %s, %s %s", i, o.Locale, o.Delivery.City, o.Delivery.Region, o.Delivery.Zip)
+		}
+		if !contains(l.services, o.DeliveryService) || !contains(l.banks, p.Bank) || !contains(l.providers, p.Provider) {
+			t.Fatalf("order %d in locale %s uses %s, %s and %s", i, o.Locale, o.DeliveryService, p.Bank, p.Provider)
+		}
+	}
+	if len(seen) != len(locales) {
+		t.Fatalf("200 orders used locales %v, want all %d", seen, len(locales))
+	}
+}
+
+func hasCity(l locale, name, region, zip string) bool {
+	for _, c := range l.cities {
+		if c.name == name && c.region == region && c.zip == zip {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
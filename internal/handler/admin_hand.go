@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"wb/internal/cache"
+	"wb/internal/dto"
+	"wb/internal/models"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+type AdminService interface {
+	DeleteOrder(ctx context.Context, orderUID string) (customerID string, ok bool, err error)
+	RestoreOrder(ctx context.Context, orderUID string) (*models.Order, error)
+	PurgeDeletedOrders(ctx context.Context, olderThan time.Duration) (int64, error)
+}
+
+type PartitionService interface {
+	Partitions(ctx context.Context) ([]models.OrderPartition, error)
+	ArchivePartition(ctx context.Context, name string) (*models.OrderPartition, error)
+	ReattachPartition(ctx context.Context, name string) (*models.OrderPartition, error)
+}
+
+type AdminHandler struct {
+	service    AdminService
+	partitions PartitionService
+	logger     *zap.SugaredLogger
+	cache      *cache.Cache
+	retention  time.Duration
+}
+
+func NewAdminHandler(logger *zap.SugaredLogger, service AdminService, partitions PartitionService,
+	cache *cache.Cache, retention time.Duration) *AdminHandler {
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
+	return &AdminHandler{
+		service:    service,
+		partitions: partitions,
+		logger:     logger,
+		cache:      cache,
+		retention:  retention,
+	}
+}
+
+func (h *AdminHandler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
+	orderUID := r.PathValue("order_uid")
+	ctx, span := startSpan(r, "DELETE /admin/orders/{order_uid}", attribute.String("order_uid", orderUID))
+	defer span.End()
+
+	customerID, deleted, err := h.service.DeleteOrder(ctx, orderUID)
+	if err != nil {
+		h.logger.Errorw("delete order failed", "order_uid", orderUID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.cache.Remove(orderUID)
+	h.cache.InvalidateCustomer(customerID)
+	h.logger.Infow("order soft-deleted", "order_uid", orderUID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) RestoreOrder(w http.ResponseWriter, r *http.Request) {
+	orderUID := r.PathValue("order_uid")
+	ctx, span := startSpan(r, "POST /admin/orders/{order_uid}/restore", attribute.String("order_uid", orderUID))
+	defer span.End()
+
+	order, err := h.service.RestoreOrder(ctx, orderUID)
+	if err != nil {
+		h.logger.Errorw("restore order failed", "order_uid", orderUID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if order == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.cache.InvalidateCustomer(order.CustomerId)
+	h.logger.Infow("order restored", "order_uid", orderUID)
+
+	var resp dto.OrderResponse
+	if err := copyOrder(&resp, order); err != nil {
+		h.logger.Errorw("dto mapping failed", "order_uid", orderUID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// Purge hard-deletes soft-deleted orders older than ?older_than (a Go
+// duration), defaulting to the configured retention.
+func (h *AdminHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startSpan(r, "POST /admin/orders/purge")
+	defer span.End()
+
+	olderThan := h.retention
+	if v := r.URL.Query().Get("older_than"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "bad older_than", http.StatusBadRequest)
+			return
+		}
+		olderThan = d
+	}
+
+	n, err := h.service.PurgeDeletedOrders(ctx, olderThan)
+	if err != nil {
+		h.logger.Errorw("purge deleted orders failed", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]int64{"purged": n})
+}
+
+func (h *AdminHandler) ListPartitions(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startSpan(r, "GET /admin/partitions")
+	defer span.End()
+
+	parts, err := h.partitions.Partitions(ctx)
+	if err != nil {
+		h.logger.Errorw("list partitions failed", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]dto.PartitionResponse, len(parts))
+	for i, p := range parts {
+		resp[i] = partitionResponse(p)
+	}
+	writeJSON(w, resp)
+}
+
+// ArchivePartition detaches a monthly partition and moves its orders to an archive file.
+func (h *AdminHandler) ArchivePartition(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ctx, span := startSpan(r, "POST /admin/partitions/{name}/archive", attribute.String("partition", name))
+	defer span.End()
+
+	p, err := h.partitions.ArchivePartition(ctx, name)
+	h.writePartition(w, r, "archive", name, p, err)
+}
+
+// ReattachPartition loads an archived partition back into the orders table.
+func (h *AdminHandler) ReattachPartition(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ctx, span := startSpan(r, "POST /admin/partitions/{name}/attach", attribute.String("partition", name))
+	defer span.End()
+
+	p, err := h.partitions.ReattachPartition(ctx, name)
+	h.writePartition(w, r, "attach", name, p, err)
+}
+
+func (h *AdminHandler) writePartition(w http.ResponseWriter, r *http.Request, op, name string, p *models.OrderPartition, err error) {
+	if errors.Is(err, models.ErrPartitionNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.logger.Errorw(op+" partition failed", "partition", name, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infow("partition "+op+" done", "partition", name, "orders", p.Orders)
+	writeJSON(w, partitionResponse(*p))
+}
+
+func partitionResponse(p models.OrderPartition) dto.PartitionResponse {
+	resp := dto.PartitionResponse{
+		Name:       p.Name,
+		Orders:     p.Orders,
+		Archived:   p.ArchivedAt != nil,
+		Path:       p.Path,
+		ArchivedAt: p.ArchivedAt,
+	}
+	if !p.From.IsZero() {
+		resp.From, resp.To = &p.From, &p.To
+	}
+	return resp
+}
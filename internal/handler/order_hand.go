@@ -3,17 +3,38 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jinzhu/copier"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 	"wb/internal/cache"
 	"wb/internal/dto"
 	"wb/internal/models"
 )
 
+var tracer = otel.Tracer("wb/internal/handler")
+
 type OrderService interface {
 	GetOrder(ctx context.Context, orderUID string) (*models.Order, error)
+	UpdateOrder(ctx context.Context, order *models.Order, expectedVersion int64) (int64, error)
+	ListOrders(ctx context.Context, f models.OrderFilter) (*models.OrderPage, error)
+	LookupOrders(ctx context.Context, key models.LookupKey, value string) ([]models.Order, error)
+	SearchOrders(ctx context.Context, q string, limit int) ([]models.SearchHit, error)
+	GetCustomerHistory(ctx context.Context, customerID string) (*models.CustomerHistory, error)
+	GetOrderHistory(ctx context.Context, orderUID string) ([]models.OrderVersion, error)
+	GetOrderAsOf(ctx context.Context, orderUID string, t time.Time) (*models.Order, error)
+	ChangeStatus(ctx context.Context, change models.StatusChange) (*models.Order, error)
+	GetStatusHistory(ctx context.Context, orderUID string) ([]models.StatusRecord, error)
+	GetItemStatusHistory(ctx context.Context, orderUID string) ([]models.ItemStatusRecord, error)
 }
 
 type OrderHandler struct {
@@ -40,16 +61,26 @@ func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
+	ctx, span := startSpan(r, "GET /order/{order_uid}", attribute.String("order_uid", orderUID))
+	defer span.End()
+
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		h.getOrderAsOf(ctx, w, r, orderUID, asOf)
+		return
+	}
 
 	if cachedOrder, ok := h.cache.GetIfInCache(orderUID); ok {
+		if notModified(w, r, cachedOrder.Version) {
+			return
+		}
 
 		var resp dto.OrderResponse
-		if err := copier.Copy(&resp, &cachedOrder); err != nil {
+		if err := copyOrder(&resp, &cachedOrder); err != nil {
 			h.logger.Errorw("dto mapping failed (cache)", "order_uid", orderUID, "err", err)
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
+		span.SetAttributes(attribute.Bool("cache.hit", true))
 		fmt.Println("From cache")
 		writeJSON(w, resp)
 		return
@@ -68,9 +99,12 @@ func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.cache.PutInCache(orderUID, *order)
+	if notModified(w, r, order.Version) {
+		return
+	}
 
 	var resp dto.OrderResponse
-	if err := copier.Copy(&resp, order); err != nil {
+	if err := copyOrder(&resp, order); err != nil {
 		h.logger.Errorw("dto mapping failed", "order_uid", orderUID, "err", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
@@ -80,6 +114,480 @@ func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, resp)
 }
 
+// PutOrder replaces an order. An If-Match header carrying the ETag from a
+// previous read makes the write conditional; a stale ETag yields 412, as
+// does "If-Match: *" for an order that does not exist.
+func (h *OrderHandler) PutOrder(w http.ResponseWriter, r *http.Request) {
+	orderUID := r.PathValue("order_uid")
+	ctx, span := startSpan(r, "PUT /order/{order_uid}", attribute.String("order_uid", orderUID))
+	defer span.End()
+
+	var expected int64
+	if v := r.Header.Get("If-Match"); v == "*" {
+		expected = models.AnyVersion
+	} else if v != "" {
+		var err error
+		if expected, err = parseETag(v); err != nil {
+			http.Error(w, "bad If-Match", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var order models.Order
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := models.DecodeOrder(dec, &order); err != nil {
+		http.Error(w, "bad order json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if order.OrderUID == "" {
+		order.OrderUID = orderUID
+	}
+	if order.OrderUID != orderUID {
+		http.Error(w, "order_uid does not match path", http.StatusBadRequest)
+		return
+	}
+	if err := order.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	ctx = models.WithAudit(ctx, models.Audit{Actor: "http-api", Source: r.RemoteAddr})
+	version, err := h.service.UpdateOrder(ctx, &order, expected)
+	var conflict *models.ConflictError
+	if errors.As(err, &conflict) {
+		if conflict.Actual > 0 {
+			w.Header().Set("ETag", etag(conflict.Actual))
+		}
+		http.Error(w, conflict.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	if errors.Is(err, models.ErrOrderDeleted) {
+		http.Error(w, "order is deleted, restore it first", http.StatusGone)
+		return
+	}
+	if err != nil {
+		h.logger.Errorw("update order failed", "order_uid", orderUID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	order.Version = version
+	h.cache.PutInCache(orderUID, order)
+	h.cache.InvalidateCustomer(order.CustomerId)
+	if order.PreviousCustomerId != "" {
+		h.cache.InvalidateCustomer(order.PreviousCustomerId)
+	}
+
+	var resp dto.OrderResponse
+	if err := copyOrder(&resp, &order); err != nil {
+		h.logger.Errorw("dto mapping failed", "order_uid", orderUID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag(version))
+	writeJSON(w, resp)
+}
+
+func (h *OrderHandler) getOrderAsOf(ctx context.Context, w http.ResponseWriter, r *http.Request, orderUID, asOf string) {
+	t, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		http.Error(w, "bad as_of: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.service.GetOrderAsOf(ctx, orderUID, t)
+	if err != nil {
+		h.logger.Errorw("get order as of failed", "order_uid", orderUID, "as_of", asOf, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if order == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var resp dto.OrderResponse
+	if err := copyOrder(&resp, order); err != nil {
+		h.logger.Errorw("dto mapping failed", "order_uid", orderUID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+func (h *OrderHandler) GetOrderHistory(w http.ResponseWriter, r *http.Request) {
+	orderUID := r.PathValue("order_uid")
+	if orderUID == "" {
+		http.Error(w, "missing order_uid", http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startSpan(r, "GET /order/{order_uid}/history", attribute.String("order_uid", orderUID))
+	defer span.End()
+
+	versions, err := h.service.GetOrderHistory(ctx, orderUID)
+	if err != nil {
+		h.logger.Errorw("get order history failed", "order_uid", orderUID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	resp := make([]dto.OrderVersionResponse, 0, len(versions))
+	for _, v := range versions {
+		item := dto.OrderVersionResponse{
+			Version:    v.Version,
+			Operation:  v.Operation,
+			RecordedAt: v.RecordedAt,
+			Source:     v.Source,
+			Actor:      v.Actor,
+		}
+		if v.Operation != models.OrderDeleted {
+			var order models.Order
+			item.Order = &dto.OrderResponse{}
+			err := json.Unmarshal(v.Snapshot, &order)
+			if err == nil {
+				err = copyOrder(item.Order, &order)
+			}
+			if err != nil {
+				h.logger.Errorw("decode order snapshot failed", "order_uid", orderUID, "version", v.Version, "err", err)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+		}
+		resp = append(resp, item)
+	}
+	writeJSON(w, resp)
+}
+
+func (h *OrderHandler) ListOrders(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startSpan(r, "GET /orders")
+	defer span.End()
+
+	f, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.service.ListOrders(ctx, f)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Errorw("list orders failed", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := dto.OrderListResponse{
+		Orders:     make([]dto.OrderResponse, 0, len(page.Orders)),
+		NextCursor: page.NextCursor,
+	}
+	if err := copyOrders(&resp.Orders, page.Orders); err != nil {
+		h.logger.Errorw("dto mapping failed", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, resp)
+}
+
+// LookupOrders serves GET /orders/{key}/{value} style routes for one lookup key.
+func (h *OrderHandler) LookupOrders(key models.LookupKey) http.HandlerFunc {
+	numeric := key == models.LookupChrtID || key == models.LookupNmID
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := r.PathValue("value")
+		if value == "" {
+			http.Error(w, "missing "+string(key), http.StatusBadRequest)
+			return
+		}
+		if _, err := strconv.Atoi(value); numeric && err != nil {
+			http.Error(w, "bad "+string(key), http.StatusBadRequest)
+			return
+		}
+
+		ctx, span := startSpan(r, "GET /orders/"+string(key), attribute.String(string(key), value))
+		defer span.End()
+
+		orders, err := h.service.LookupOrders(ctx, key, value)
+		if err != nil {
+			h.logger.Errorw("lookup orders failed", "key", key, "value", value, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if len(orders) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		resp := make([]dto.OrderResponse, 0, len(orders))
+		if err := copyOrders(&resp, orders); err != nil {
+			h.logger.Errorw("dto mapping failed", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, resp)
+	}
+}
+
+// SearchOrders serves GET /orders/search?q=...&limit=... Highlights mark the
+// matched words with <mark> tags; the rest of the text is not escaped.
+func (h *OrderHandler) SearchOrders(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "missing q", http.StatusBadRequest)
+		return
+	}
+	limit, err := optionalInt(r.URL.Query(), "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startSpan(r, "GET /orders/search", attribute.String("q", q))
+	defer span.End()
+
+	n := 0
+	if limit != nil {
+		n = *limit
+	}
+	hits, err := h.service.SearchOrders(ctx, q, n)
+	if err != nil {
+		h.logger.Errorw("search orders failed", "q", q, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := dto.OrderSearchResponse{
+		Query:   q,
+		Results: make([]dto.OrderSearchResult, 0, len(hits)),
+	}
+	for _, hit := range hits {
+		res := dto.OrderSearchResult{Rank: hit.Rank, Highlights: hit.Highlights}
+		if err := copyOrder(&res.Order, &hit.Order); err != nil {
+			h.logger.Errorw("dto mapping failed", "order_uid", hit.Order.OrderUID, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		resp.Results = append(resp.Results, res)
+	}
+	writeJSON(w, resp)
+}
+
+func (h *OrderHandler) GetCustomerOrders(w http.ResponseWriter, r *http.Request) {
+	customerID := r.PathValue("customer_id")
+	if customerID == "" {
+		http.Error(w, "missing customer_id", http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startSpan(r, "GET /customers/{customer_id}/orders", attribute.String("customer_id", customerID))
+	defer span.End()
+
+	history, ok := h.cache.GetCustomerHistory(customerID)
+	if !ok {
+		fromDB, err := h.service.GetCustomerHistory(ctx, customerID)
+		if err != nil {
+			if errors.Is(err, models.ErrMoneyOverflow) {
+				http.Error(w, "customer total is out of range", http.StatusUnprocessableEntity)
+				return
+			}
+			h.logger.Errorw("get customer history failed", "customer_id", customerID, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if fromDB == nil {
+			http.NotFound(w, r)
+			return
+		}
+		history = *fromDB
+		h.cache.PutCustomerHistory(customerID, history)
+	}
+	span.SetAttributes(attribute.Bool("cache.hit", ok))
+
+	resp := dto.CustomerOrdersResponse{
+		CustomerId:     history.CustomerID,
+		OrderCount:     history.OrderCount,
+		TotalSpent:     make([]dto.Money, 0, len(history.TotalSpent)),
+		TotalReporting: make([]dto.Money, 0, len(history.TotalReporting)),
+		FirstOrderAt:   history.FirstOrderAt,
+		LastOrderAt:    history.LastOrderAt,
+		Orders:         make([]dto.OrderResponse, 0, len(history.Orders)),
+	}
+	for _, m := range history.TotalSpent {
+		resp.TotalSpent = append(resp.TotalSpent, money(m))
+	}
+	for _, m := range history.TotalReporting {
+		resp.TotalReporting = append(resp.TotalReporting, money(m))
+	}
+	if err := copyOrders(&resp.Orders, history.Orders); err != nil {
+		h.logger.Errorw("dto mapping failed", "customer_id", customerID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+func parseOrderFilter(q url.Values) (models.OrderFilter, error) {
+	f := models.OrderFilter{
+		CustomerID:      q.Get("customer_id"),
+		TrackNumber:     q.Get("track_number"),
+		DeliveryService: q.Get("delivery_service"),
+		Locale:          q.Get("locale"),
+		Currency:        q.Get("currency"),
+		Provider:        q.Get("provider"),
+		Bank:            q.Get("bank"),
+		Cursor:          q.Get("cursor"),
+	}
+
+	var err error
+	if v := q.Get("created_from"); v != "" {
+		if f.CreatedFrom, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("bad created_from: %w", err)
+		}
+	}
+	if v := q.Get("created_to"); v != "" {
+		if f.CreatedTo, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("bad created_to: %w", err)
+		}
+	}
+	if f.AmountMin, err = optionalInt64(q, "amount_min"); err != nil {
+		return f, err
+	}
+	if f.AmountMax, err = optionalInt64(q, "amount_max"); err != nil {
+		return f, err
+	}
+	if v := q.Get("item_status"); v != "" {
+		f.ItemStatus = models.OrderStatus(v)
+		if !f.ItemStatus.Valid() {
+			return f, fmt.Errorf("bad item_status %q", v)
+		}
+	}
+	if limit, err := optionalInt(q, "limit"); err != nil {
+		return f, err
+	} else if limit != nil {
+		f.Limit = *limit
+	}
+
+	switch v := q.Get("sort"); v {
+	case "", models.SortByDate, models.SortByAmount:
+		f.SortBy = v
+	default:
+		return f, fmt.Errorf("bad sort %q, want date or amount", v)
+	}
+	switch v := q.Get("order"); v {
+	case "", "desc":
+	case "asc":
+		f.Asc = true
+	default:
+		return f, fmt.Errorf("bad order %q, want asc or desc", v)
+	}
+
+	return f, nil
+}
+
+func optionalInt(q url.Values, key string) (*int, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s: %w", key, err)
+	}
+	return &n, nil
+}
+
+func optionalInt64(q url.Values, key string) (*int64, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s: %w", key, err)
+	}
+	return &n, nil
+}
+
+// copyOrder maps o onto dst. copier handles the plain fields; amounts are
+// rendered as money objects formatted for their currency.
+func copyOrder(dst *dto.OrderResponse, o *models.Order) error {
+	if err := copier.Copy(dst, o); err != nil {
+		return err
+	}
+	p := o.Payment
+	dst.Payment.Amount = money(p.Amount)
+	dst.Payment.DeliveryCost = money(p.DeliveryCost)
+	dst.Payment.GoodsTotal = money(p.GoodsTotal)
+	dst.Payment.CustomFee = money(p.CustomFee)
+	dst.Payment.Reporting = nil
+	if m, ok := p.Reporting(); ok {
+		r := money(m)
+		dst.Payment.Reporting = &r
+	}
+	for i, it := range o.Items {
+		dst.Items[i].Price = money(it.Price)
+		dst.Items[i].TotalPrice = money(it.TotalPrice)
+	}
+	return nil
+}
+
+func copyOrders(dst *[]dto.OrderResponse, orders []models.Order) error {
+	for i := range orders {
+		var resp dto.OrderResponse
+		if err := copyOrder(&resp, &orders[i]); err != nil {
+			return err
+		}
+		*dst = append(*dst, resp)
+	}
+	return nil
+}
+
+func money(m models.Money) dto.Money {
+	return dto.Money{Amount: m.Amount, Currency: m.Currency, Formatted: m.String()}
+}
+
+func startSpan(r *http.Request, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+func parseETag(v string) (int64, error) {
+	v = strings.TrimPrefix(v, "W/")
+	s, err := strconv.Unquote(v)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// notModified sets the ETag header and answers 304 when the client already
+// has this version.
+func notModified(w http.ResponseWriter, r *http.Request, version int64) bool {
+	tag := etag(version)
+	w.Header().Set("ETag", tag)
+	if r.Header.Get("If-None-Match") == tag {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
 func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"wb/internal/dto"
+	"wb/internal/models"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// GetOrderStatus returns the current status of an order, the statuses it may
+// change to and its status history.
+func (h *OrderHandler) GetOrderStatus(w http.ResponseWriter, r *http.Request) {
+	orderUID := r.PathValue("order_uid")
+	ctx, span := startSpan(r, "GET /order/{order_uid}/status", attribute.String("order_uid", orderUID))
+	defer span.End()
+
+	order, err := h.service.GetOrder(ctx, orderUID)
+	if err != nil {
+		h.logger.Errorw("get order failed", "order_uid", orderUID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if order == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.writeStatus(ctx, w, order)
+}
+
+// ChangeOrderStatus moves an order to the requested status. Changes the order
+// lifecycle does not allow yield 409.
+func (h *OrderHandler) ChangeOrderStatus(w http.ResponseWriter, r *http.Request) {
+	orderUID := r.PathValue("order_uid")
+	ctx, span := startSpan(r, "POST /order/{order_uid}/status", attribute.String("order_uid", orderUID))
+	defer span.End()
+
+	req, ok := decodeStatusChange(w, r)
+	if !ok {
+		return
+	}
+	span.SetAttributes(attribute.String("status", req.Status))
+
+	if order, ok := h.changeStatus(ctx, w, r, req); ok {
+		h.writeStatus(ctx, w, order)
+	}
+}
+
+// GetItemStatuses returns the status of each item of an order with the
+// statuses it may change to and its status history.
+func (h *OrderHandler) GetItemStatuses(w http.ResponseWriter, r *http.Request) {
+	orderUID := r.PathValue("order_uid")
+	ctx, span := startSpan(r, "GET /order/{order_uid}/items/status", attribute.String("order_uid", orderUID))
+	defer span.End()
+
+	order, err := h.service.GetOrder(ctx, orderUID)
+	if err != nil {
+		h.logger.Errorw("get order failed", "order_uid", orderUID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if order == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.writeItemStatuses(ctx, w, order)
+}
+
+// ChangeItemStatuses moves the listed items of an order to the requested
+// status, for example when an order ships partially.
+func (h *OrderHandler) ChangeItemStatuses(w http.ResponseWriter, r *http.Request) {
+	orderUID := r.PathValue("order_uid")
+	ctx, span := startSpan(r, "POST /order/{order_uid}/items/status", attribute.String("order_uid", orderUID))
+	defer span.End()
+
+	req, ok := decodeStatusChange(w, r)
+	if !ok {
+		return
+	}
+	if len(req.Items) == 0 {
+		http.Error(w, "items are required", http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(attribute.String("status", req.Status), attribute.Int("items", len(req.Items)))
+
+	if order, ok := h.changeStatus(ctx, w, r, req); ok {
+		h.writeItemStatuses(ctx, w, order)
+	}
+}
+
+func decodeStatusChange(w http.ResponseWriter, r *http.Request) (dto.StatusChangeRequest, bool) {
+	var req dto.StatusChangeRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "bad status json: "+err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// changeStatus applies req to the order of the request and writes the error
+// response if that fails.
+func (h *OrderHandler) changeStatus(ctx context.Context, w http.ResponseWriter, r *http.Request, req dto.StatusChangeRequest) (*models.Order, bool) {
+	orderUID := r.PathValue("order_uid")
+	change := models.StatusChange{
+		OrderUID: orderUID,
+		Status:   models.OrderStatus(req.Status),
+		Reason:   req.Reason,
+	}
+	for _, it := range req.Items {
+		change.Items = append(change.Items, models.ItemRef{ChrtId: it.ChrtId, Rid: it.Rid})
+	}
+	if req.ChangedAt != nil {
+		change.ChangedAt = *req.ChangedAt
+	}
+
+	ctx = models.WithAudit(ctx, models.Audit{Actor: "http-api", Source: r.RemoteAddr})
+	order, err := h.service.ChangeStatus(ctx, change)
+	var transition *models.StatusTransitionError
+	switch {
+	case errors.Is(err, models.ErrInvalidStatus), errors.Is(err, models.ErrItemNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	case errors.As(err, &transition):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return nil, false
+	case err != nil:
+		h.logger.Errorw("change order status failed", "order_uid", orderUID, "status", req.Status, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	case order == nil:
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	h.cache.PutInCache(orderUID, *order)
+	h.cache.InvalidateCustomer(order.CustomerId)
+	w.Header().Set("ETag", etag(order.Version))
+	return order, true
+}
+
+func (h *OrderHandler) writeStatus(ctx context.Context, w http.ResponseWriter, order *models.Order) {
+	history, err := h.service.GetStatusHistory(ctx, order.OrderUID)
+	if err != nil {
+		h.logger.Errorw("get status history failed", "order_uid", order.OrderUID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := dto.OrderStatusResponse{
+		OrderUID: order.OrderUID,
+		Status:   string(order.Status),
+		Next:     nextStatuses(order.Status),
+		History:  make([]dto.StatusRecordResponse, 0, len(history)),
+	}
+	if len(order.Items) > 0 {
+		resp.Items = make(map[string]int)
+		for _, it := range order.Items {
+			resp.Items[string(it.FulfillmentStatus)]++
+		}
+	}
+	for _, rec := range history {
+		resp.History = append(resp.History, statusRecord(rec))
+	}
+	writeJSON(w, resp)
+}
+
+func (h *OrderHandler) writeItemStatuses(ctx context.Context, w http.ResponseWriter, order *models.Order) {
+	history, err := h.service.GetItemStatusHistory(ctx, order.OrderUID)
+	if err != nil {
+		h.logger.Errorw("get item status history failed", "order_uid", order.OrderUID, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	byItem := make(map[models.ItemRef][]dto.StatusRecordResponse)
+	for _, rec := range history {
+		byItem[rec.ItemRef] = append(byItem[rec.ItemRef], statusRecord(rec.StatusRecord))
+	}
+
+	resp := dto.ItemStatusesResponse{
+		OrderUID: order.OrderUID,
+		Status:   string(order.Status),
+		Items:    make([]dto.ItemStatusResponse, 0, len(order.Items)),
+	}
+	for _, it := range order.Items {
+		item := dto.ItemStatusResponse{
+			ChrtId:    it.ChrtId,
+			Rid:       it.Rid,
+			Name:      it.Name,
+			Status:    string(it.FulfillmentStatus),
+			ChangedAt: it.StatusChangedAt,
+			Next:      nextStatuses(it.FulfillmentStatus),
+			History:   byItem[it.Ref()],
+		}
+		if item.History == nil {
+			item.History = []dto.StatusRecordResponse{}
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	writeJSON(w, resp)
+}
+
+func nextStatuses(s models.OrderStatus) []string {
+	next := make([]string, 0, len(s.Next()))
+	for _, n := range s.Next() {
+		next = append(next, string(n))
+	}
+	return next
+}
+
+func statusRecord(rec models.StatusRecord) dto.StatusRecordResponse {
+	return dto.StatusRecordResponse{
+		From:       string(rec.From),
+		Status:     string(rec.Status),
+		Reason:     rec.Reason,
+		ChangedAt:  rec.ChangedAt,
+		RecordedAt: rec.RecordedAt,
+		Source:     rec.Source,
+		Actor:      rec.Actor,
+	}
+}
@@ -4,15 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 	"wb/internal/cache"
 
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"wb/internal/models"
 	"wb/internal/service"
 )
 
+const consumerActor = "kafka-consumer"
+
 type Consumer struct {
 	reader  *kafka.Reader
 	logger  *zap.SugaredLogger
@@ -53,29 +60,23 @@ func (c *Consumer) Start(ctx context.Context) error {
 			continue
 		}
 
-		var order models.Order
-		if err := json.Unmarshal(m.Value, &order); err != nil {
-			c.logger.Errorw("bad message json", "err", err, "payload", string(m.Value))
-			continue
-		}
-
-		if order.OrderUID == "" {
-			c.logger.Errorw("message missing order_uid", "payload", string(m.Value))
-			continue
-		}
+		msgCtx, span := c.startSpan(ctx, &m)
 
-		orderUid, err := c.service.UpsertOrder(ctx, order)
+		order, err := c.handle(msgCtx, m)
 		if err != nil {
-			c.logger.Errorw("upsert failed", "order_uid", order.OrderUID, "err", err)
-			continue
+			span.End()
+			c.logger.Infow("kafka consumer stopped")
+			return nil
 		}
 
-		c.cache.PutInCache(orderUid, order)
-
-		if err := c.reader.CommitMessages(ctx, m); err != nil {
+		if err := c.reader.CommitMessages(msgCtx, m); err != nil {
 			c.logger.Errorw("commit failed", "order_uid", order.OrderUID, "err", err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "commit")
+			span.End()
 			continue
 		}
+		span.End()
 
 		c.logger.Infow("message processed",
 			"order_uid", order.OrderUID,
@@ -84,3 +85,93 @@ func (c *Consumer) Start(ctx context.Context) error {
 		)
 	}
 }
+
+func (c *Consumer) startSpan(ctx context.Context, m *kafka.Message) (context.Context, trace.Span) {
+	parent := otel.GetTextMapPropagator().Extract(ctx, headerCarrier{msg: m})
+	return tracer.Start(parent, "kafka.consume",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", m.Topic),
+			attribute.Int("messaging.kafka.partition", m.Partition),
+			attribute.Int64("messaging.kafka.offset", m.Offset),
+		),
+	)
+}
+
+// handle writes the order in m, retrying transient failures. Malformed
+// messages and orders failing otherwise are logged and skipped, like in a
+// batch. It returns an error only when ctx is done, and then m must not be
+// committed.
+func (c *Consumer) handle(ctx context.Context, m kafka.Message) (models.Order, error) {
+	order, ok := c.decode(ctx, m)
+	if !ok {
+		return order, nil
+	}
+
+	upsertCtx, span := tracer.Start(models.WithAudit(ctx, models.Audit{
+		Actor:  consumerActor,
+		Source: fmt.Sprintf("%s/%d@%d", m.Topic, m.Partition, m.Offset),
+	}), "order.upsert")
+	var version int64
+	err := retry(upsertCtx, c.logger, func() (err error) {
+		version, err = c.service.UpsertOrder(upsertCtx, &order)
+		return err
+	})
+	endSpan(span, err)
+	switch {
+	case ctx.Err() != nil:
+		return order, ctx.Err()
+	case errors.Is(err, models.ErrOrderDeleted):
+		c.logger.Warnw("order is deleted, message skipped", "order_uid", order.OrderUID)
+		return order, nil
+	case err != nil:
+		c.logger.Errorw("order skipped", "order_uid", order.OrderUID,
+			"partition", m.Partition, "offset", m.Offset, "err", err)
+		return order, nil
+	}
+
+	_, span = tracer.Start(ctx, "order.cache")
+	order.Version = version
+	c.cache.PutInCache(order.OrderUID, order)
+	c.cache.InvalidateCustomer(order.CustomerId)
+	if order.PreviousCustomerId != "" {
+		c.cache.InvalidateCustomer(order.PreviousCustomerId)
+	}
+	span.End()
+
+	return order, nil
+}
+
+func (c *Consumer) decode(ctx context.Context, m kafka.Message) (models.Order, bool) {
+	var order models.Order
+
+	_, span := tracer.Start(ctx, "order.decode")
+	err := json.Unmarshal(m.Value, &order)
+	endSpan(span, err)
+	if err != nil {
+		c.logger.Errorw("bad message json, skipped", "err", err, "payload", string(m.Value))
+		return order, false
+	}
+
+	_, span = tracer.Start(ctx, "order.validate")
+	if order.OrderUID == "" {
+		err = errors.New("missing order_uid")
+	}
+	endSpan(span, err)
+	if err != nil {
+		c.logger.Errorw("message missing order_uid, skipped", "payload", string(m.Value))
+		return order, false
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("order_uid", order.OrderUID))
+
+	return order, true
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,154 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"wb/internal/models"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StartBatch consumes up to size messages at a time, waiting at most wait for
+// a batch to fill, and writes the valid orders with a single bulk upsert.
+// Offsets are committed only after the whole batch is stored; when the bulk
+// upsert fails the orders are written one by one.
+func (c *Consumer) StartBatch(ctx context.Context, size int, wait time.Duration) error {
+	cfg := c.reader.Config()
+	c.logger.Infow("kafka batch consumer started",
+		"brokers", cfg.Brokers, "topic", cfg.Topic, "group", cfg.GroupID,
+		"batch_size", size, "batch_wait", wait)
+
+	for {
+		batch, err := c.fetchBatch(ctx, size, wait)
+		if errors.Is(err, context.Canceled) {
+			c.logger.Infow("kafka consumer stopped")
+			return nil
+		}
+		if err != nil {
+			c.logger.Errorw("fetch message failed", "err", err)
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+
+		c.processBatch(ctx, batch)
+	}
+}
+
+func (c *Consumer) fetchBatch(ctx context.Context, size int, wait time.Duration) ([]kafka.Message, error) {
+	first, err := c.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := []kafka.Message{first}
+
+	fillCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	for len(batch) < size {
+		m, err := c.reader.FetchMessage(fillCtx)
+		if err != nil {
+			if fillCtx.Err() != nil && ctx.Err() == nil {
+				break
+			}
+			return batch, err
+		}
+		batch = append(batch, m)
+	}
+	return batch, nil
+}
+
+func (c *Consumer) processBatch(ctx context.Context, batch []kafka.Message) {
+	links := make([]trace.Link, 0, len(batch))
+	for i := range batch {
+		msgCtx := otel.GetTextMapPropagator().Extract(ctx, headerCarrier{msg: &batch[i]})
+		links = append(links, trace.LinkFromContext(msgCtx))
+	}
+	ctx, span := tracer.Start(ctx, "kafka.consume.batch",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(batch))),
+	)
+	defer span.End()
+
+	orders := make([]models.Order, 0, len(batch))
+	sources := make([]kafka.Message, 0, len(batch))
+	for _, m := range batch {
+		if order, ok := c.decode(ctx, m); ok {
+			orders = append(orders, order)
+			sources = append(sources, m)
+		}
+	}
+
+	first, last := batch[0], batch[len(batch)-1]
+	upsertCtx, upsertSpan := tracer.Start(models.WithAudit(ctx, models.Audit{
+		Actor:  consumerActor,
+		Source: fmt.Sprintf("%s/%d@%d-%d", first.Topic, first.Partition, first.Offset, last.Offset),
+	}), "order.bulk_upsert")
+	n, err := c.service.UpsertOrders(upsertCtx, orders)
+	endSpan(upsertSpan, err)
+	if err != nil {
+		c.logger.Warnw("bulk upsert failed, writing orders one by one", "orders", len(orders), "err", err)
+		if n, err = c.upsertEach(ctx, orders, sources); err != nil {
+			return
+		}
+	}
+
+	// Bulk writes do not report per-order versions, so drop stale entries
+	// and let reads repopulate the cache.
+	for _, o := range orders {
+		c.cache.Remove(o.OrderUID)
+		c.cache.InvalidateCustomer(o.CustomerId)
+		if o.PreviousCustomerId != "" {
+			c.cache.InvalidateCustomer(o.PreviousCustomerId)
+		}
+	}
+
+	if err := c.reader.CommitMessages(ctx, batch...); err != nil {
+		c.logger.Errorw("commit failed", "messages", len(batch), "err", err)
+		return
+	}
+
+	c.logger.Infow("batch processed",
+		"messages", len(batch),
+		"orders", n,
+		"partition", last.Partition,
+		"offset", last.Offset,
+	)
+}
+
+// upsertEach writes orders one at a time after a failed bulk upsert, so one
+// bad order does not hold back the rest of the batch. Transient errors are
+// retried; orders failing otherwise are logged and skipped. It returns the
+// number of orders written, or an error only when ctx is done.
+func (c *Consumer) upsertEach(ctx context.Context, orders []models.Order, sources []kafka.Message) (int, error) {
+	n := 0
+	for i := range orders {
+		m := sources[i]
+		upsertCtx, span := tracer.Start(models.WithAudit(ctx, models.Audit{
+			Actor:  consumerActor,
+			Source: fmt.Sprintf("%s/%d@%d", m.Topic, m.Partition, m.Offset),
+		}), "order.upsert")
+		err := retry(upsertCtx, c.logger, func() error {
+			_, err := c.service.UpsertOrder(upsertCtx, &orders[i])
+			return err
+		})
+		endSpan(span, err)
+		switch {
+		case err == nil:
+			n++
+		case ctx.Err() != nil:
+			return n, ctx.Err()
+		case errors.Is(err, models.ErrOrderDeleted):
+			c.logger.Warnw("order is deleted, message skipped", "order_uid", orders[i].OrderUID)
+		default:
+			c.logger.Errorw("order skipped", "order_uid", orders[i].OrderUID,
+				"partition", m.Partition, "offset", m.Offset, "err", err)
+		}
+	}
+	return n, nil
+}
@@ -3,15 +3,21 @@ package kafka
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"math/rand"
+	"github.com/pkg/errors"
 	"time"
+	"wb/internal/generator"
 	"wb/internal/models"
 
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+var tracer = otel.Tracer("wb/internal/kafka")
+
 type Producer struct {
 	w      *kafka.Writer
 	topic  string
@@ -25,8 +31,9 @@ func NewProducer(brokers []string, topic string, logger *zap.SugaredLogger) *Pro
 	w := &kafka.Writer{
 		Addr:         kafka.TCP(brokers...),
 		Topic:        topic,
-		Balancer:     &kafka.LeastBytes{},
+		Balancer:     &kafka.Hash{},
 		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 10 * time.Millisecond,
 		Async:        false,
 	}
 	return &Producer{w: w, topic: topic, logger: logger}
@@ -34,11 +41,16 @@ func NewProducer(brokers []string, topic string, logger *zap.SugaredLogger) *Pro
 
 func (p *Producer) Close() error { return p.w.Close() }
 
-func (p *Producer) Run(ctx context.Context, interval time.Duration) error {
+// Run sends a generated order every interval; mix makes a share of them
+// faulty, as in cmd/loadgen.
+func (p *Producer) Run(ctx context.Context, interval time.Duration, mix generator.FaultMix) error {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	p.logger.Infow("kafka producer started", "topic", p.topic)
+	p.logger.Infow("kafka producer started", "topic", p.topic, "interval", interval, "faults", len(mix))
+
+	seed := time.Now().UnixNano()
+	injector := generator.NewFaultInjector(generator.New(seed, time.Now()), seed, mix)
 
 	for {
 		select {
@@ -46,75 +58,51 @@ func (p *Producer) Run(ctx context.Context, interval time.Duration) error {
 			p.logger.Infow("kafka producer stopped")
 			return nil
 		case <-ticker.C:
-			key, val, orderUID := randomOrderJSON()
-			err := p.w.WriteMessages(ctx, kafka.Message{
-				Key:   []byte(key),
-				Value: val,
-				Time:  time.Now(),
-			})
-			if err != nil {
-				p.logger.Errorw("produce failed", "order_uid", orderUID, "err", err)
-				continue
+			for _, m := range injector.Next(0) {
+				if err := p.ProduceRaw(ctx, m.Key, m.Value); err != nil {
+					p.logger.Errorw("produce failed", "order_uid", m.OrderUID, "fault", m.Fault, "err", err)
+					continue
+				}
+				p.logger.Infow("produced order", "order_uid", m.OrderUID, "fault", m.Fault)
 			}
-			p.logger.Infow("produced order", "order_uid", orderUID)
 		}
 	}
 }
 
-func randomOrderJSON() (key string, value []byte, orderUID string) {
-	now := time.Now().UTC()
-	orderUID = fmt.Sprintf("ord-%d", rand.Int63())
-	track := fmt.Sprintf("TRK%06d", rand.Intn(1_000_000))
-	o := models.Order{
-		OrderUID:    orderUID,
-		TrackNumber: track,
-		Entry:       "WBIL",
-		Delivery: models.Delivery{
-			Name:    "Test Testov",
-			Phone:   "+9720000000",
-			Zip:     "2639809",
-			City:    "Kiryat Mozkin",
-			Address: "Ploshad Mira 15",
-			Region:  "Kraiot",
-			Email:   "test@gmail.com",
-		},
-		Payment: models.Payment{
-			Transaction:  orderUID,
-			RequestId:    "",
-			Currency:     "USD",
-			Provider:     "wbpay",
-			Amount:       1000 + rand.Intn(2000),
-			PaymentDt:    now.Unix(),
-			Bank:         "alpha",
-			DeliveryCost: 1500,
-			GoodsTotal:   317,
-			CustomFee:    0,
-		},
-		Items: []models.Item{
-			{
-				ChrtId:      900000 + rand.Intn(999999),
-				TrackNumber: track,
-				Price:       300 + rand.Intn(700),
-				Rid:         "rid" + string(rune(rand.Intn(9999))),
-				Name:        "Mascaras",
-				Sale:        30,
-				Size:        "0",
-				TotalPrice:  317,
-				NmId:        2389212,
-				Brand:       "Vivienne Sabo",
-				Status:      202,
-			},
-		},
-		Locale:            "en",
-		InternalSignature: "",
-		CustomerId:        "test",
-		DeliveryService:   "meest",
-		ShardKey:          "9",
-		SmId:              99,
-		DateCreated:       time.Now(),
-		OofShard:          "1",
+func (p *Producer) Produce(ctx context.Context, order models.Order) error {
+	val, err := json.Marshal(order)
+	if err != nil {
+		return errors.WithMessage(err, "marshal order")
 	}
+	return p.publish(ctx, order.OrderUID, val, order.OrderUID)
+}
+
+func (p *Producer) ProduceRaw(ctx context.Context, key, value []byte) error {
+	return p.publish(ctx, string(key), value, string(key))
+}
 
-	b, _ := json.Marshal(o)
-	return orderUID, b, orderUID
+func (p *Producer) publish(ctx context.Context, key string, val []byte, orderUID string) error {
+	ctx, span := tracer.Start(ctx, "kafka.produce",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", p.topic),
+			attribute.String("order_uid", orderUID),
+		),
+	)
+	defer span.End()
+
+	msg := kafka.Message{
+		Key:   []byte(key),
+		Value: val,
+		Time:  time.Now(),
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{msg: &msg})
+
+	if err := p.w.WriteMessages(ctx, msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "write message")
+		return err
+	}
+	return nil
 }
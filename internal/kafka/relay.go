@@ -0,0 +1,102 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+	"wb/internal/models"
+	"wb/internal/repository"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+const relayBatchSize = 100
+
+type Relay struct {
+	w         *kafka.Writer
+	topic     string
+	outbox    *repository.OutboxRepo
+	logger    *zap.SugaredLogger
+	retention time.Duration
+}
+
+func NewRelay(brokers []string, topic string, outbox *repository.OutboxRepo, retention time.Duration, logger *zap.SugaredLogger) *Relay {
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 10 * time.Millisecond,
+		Async:        false,
+	}
+	return &Relay{w: w, topic: topic, outbox: outbox, logger: logger, retention: retention}
+}
+
+func (r *Relay) Close() error { return r.w.Close() }
+
+// Run publishes outbox events every interval until ctx is done. Events are
+// marked published only after Kafka acknowledged them, so a crash in between
+// results in redelivery rather than loss.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	r.logger.Infow("outbox relay started", "topic", r.topic, "interval", interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Infow("outbox relay stopped")
+			return nil
+		case <-cleanup.C:
+			n, err := r.outbox.DeletePublished(ctx, r.retention)
+			if err != nil {
+				r.logger.Errorw("outbox cleanup failed", "err", err)
+				continue
+			}
+			r.logger.Infow("outbox cleaned up", "deleted", n)
+		case <-ticker.C:
+			for {
+				n, err := r.outbox.Relay(ctx, relayBatchSize, func(events []models.OrderEvent) error {
+					return r.publish(ctx, events)
+				})
+				if err != nil {
+					r.logger.Errorw("outbox relay failed", "err", err)
+					break
+				}
+				if n > 0 {
+					r.logger.Infow("order events published", "count", n)
+				}
+				if n < relayBatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, events []models.OrderEvent) error {
+	msgs := make([]kafka.Message, 0, len(events))
+	for _, e := range events {
+		val, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(e.OrderUID),
+			Value: val,
+			Headers: []kafka.Header{
+				{Key: "event_type", Value: []byte(e.Type)},
+			},
+			Time: e.CreatedAt,
+		})
+	}
+	return r.w.WriteMessages(ctx, msgs...)
+}
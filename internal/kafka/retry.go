@@ -0,0 +1,42 @@
+package kafka
+
+import (
+	"context"
+	"time"
+	"wb/pkg/postgres"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const maxRetryAttempts = 10
+
+// Vars rather than consts so that tests do not wait for real backoffs.
+var (
+	retryBackoff    = 500 * time.Millisecond
+	maxRetryBackoff = 30 * time.Second
+)
+
+// retry calls fn until it succeeds, fails with an error that is not
+// transient, ctx is done or maxRetryAttempts attempts failed, backing off
+// exponentially between attempts. A message that keeps failing is then
+// skipped like any other failure instead of stalling its partition.
+func retry(ctx context.Context, logger *zap.SugaredLogger, fn func() error) error {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !postgres.IsTransient(err) {
+			return err
+		}
+		if attempt == maxRetryAttempts {
+			return errors.WithMessagef(err, "gave up after %d attempts", attempt)
+		}
+		logger.Warnw("transient error, retrying", "attempt", attempt, "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
@@ -0,0 +1,67 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// setBackoff sets the retry backoff for one test.
+func setBackoff(t *testing.T, d time.Duration) {
+	backoff, maxBackoff := retryBackoff, maxRetryBackoff
+	t.Cleanup(func() { retryBackoff, maxRetryBackoff = backoff, maxBackoff })
+	retryBackoff, maxRetryBackoff = d, d
+}
+
+func TestRetry(t *testing.T) {
+	setBackoff(t, time.Microsecond)
+
+	deadlock := &pq.Error{Code: "40P01"}
+	tests := []struct {
+		name     string
+		errs     []error // returned by the attempts in turn, then the last one forever
+		attempts int
+		wantErr  bool
+	}{
+		{"success", []error{nil}, 1, false},
+		{"transient then success", []error{deadlock, deadlock, nil}, 3, false},
+		{"not transient", []error{&pq.Error{Code: "23505"}}, 1, true},
+		{"statement timeout", []error{&pq.Error{Code: "57014"}}, 1, true},
+		{"transient forever", []error{deadlock}, maxRetryAttempts, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			err := retry(context.Background(), zap.NewNop().Sugar(), func() error {
+				attempts++
+				return tc.errs[min(attempts, len(tc.errs))-1]
+			})
+			if attempts != tc.attempts {
+				t.Fatalf("attempts = %d, want %d", attempts, tc.attempts)
+			}
+			var pqErr *pq.Error
+			if got := errors.As(err, &pqErr); got != tc.wantErr {
+				t.Fatalf("err = %v, want the database error: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWithContext(t *testing.T) {
+	setBackoff(t, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := retry(ctx, zap.NewNop().Sugar(), func() error {
+		attempts++
+		cancel()
+		return &pq.Error{Code: "08006"}
+	})
+	if !errors.Is(err, context.Canceled) || attempts != 1 {
+		t.Fatalf("err = %v after %d attempts, want context.Canceled after 1", err, attempts)
+	}
+}
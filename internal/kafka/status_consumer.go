@@ -0,0 +1,130 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"wb/internal/cache"
+	"wb/internal/models"
+	"wb/internal/service"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// StatusConsumer applies order and item status changes published as
+// models.StatusChange messages. Malformed messages and changes the order
+// lifecycle does not allow are logged and committed, so they are not
+// retried; transient failures are retried until they succeed.
+type StatusConsumer struct {
+	Consumer
+}
+
+func NewStatusConsumer(brokers []string, topic, groupID string, logger *zap.SugaredLogger, svc *service.OrderService, cache *cache.Cache) *StatusConsumer {
+	return &StatusConsumer{Consumer: *NewConsumer(brokers, topic, groupID, logger, svc, cache)}
+}
+
+func (c *StatusConsumer) Start(ctx context.Context) error {
+	cfg := c.reader.Config()
+	c.logger.Infow("kafka status consumer started",
+		"brokers", cfg.Brokers, "topic", cfg.Topic, "group", cfg.GroupID)
+
+	for {
+		m, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				c.logger.Infow("kafka status consumer stopped")
+				return nil
+			}
+			c.logger.Errorw("fetch status message failed", "err", err)
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+
+		msgCtx, span := c.startSpan(ctx, &m)
+
+		change, err := c.handle(msgCtx, m)
+		if err != nil {
+			span.End()
+			c.logger.Infow("kafka status consumer stopped")
+			return nil
+		}
+
+		if err := c.reader.CommitMessages(msgCtx, m); err != nil {
+			c.logger.Errorw("commit failed", "order_uid", change.OrderUID, "err", err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "commit")
+			span.End()
+			continue
+		}
+		span.End()
+
+		c.logger.Infow("status message processed",
+			"order_uid", change.OrderUID,
+			"status", change.Status,
+			"partition", m.Partition,
+			"offset", m.Offset,
+		)
+	}
+}
+
+// handle applies the change in m, retrying transient failures. Malformed,
+// rejected and otherwise failing changes are logged and skipped. It returns
+// an error only when ctx is done, and then m must not be committed.
+func (c *StatusConsumer) handle(ctx context.Context, m kafka.Message) (models.StatusChange, error) {
+	var change models.StatusChange
+
+	_, span := tracer.Start(ctx, "status.decode")
+	err := json.Unmarshal(m.Value, &change)
+	if err == nil && change.OrderUID == "" {
+		err = errors.New("missing order_uid")
+	}
+	endSpan(span, err)
+	if err != nil {
+		c.logger.Errorw("bad status message, skipped", "err", err, "payload", string(m.Value))
+		return change, nil
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("order_uid", change.OrderUID),
+		attribute.String("status", string(change.Status)),
+	)
+
+	changeCtx, span := tracer.Start(models.WithAudit(ctx, models.Audit{
+		Actor:  consumerActor,
+		Source: fmt.Sprintf("%s/%d@%d", m.Topic, m.Partition, m.Offset),
+	}), "order.status")
+	var order *models.Order
+	err = retry(changeCtx, c.logger, func() (err error) {
+		order, err = c.service.ChangeStatus(changeCtx, change)
+		return err
+	})
+	endSpan(span, err)
+
+	var transition *models.StatusTransitionError
+	switch {
+	case ctx.Err() != nil:
+		return change, ctx.Err()
+	case errors.Is(err, models.ErrInvalidStatus), errors.Is(err, models.ErrItemNotFound), errors.As(err, &transition):
+		c.logger.Warnw("status change rejected", "order_uid", change.OrderUID, "status", change.Status, "err", err)
+		return change, nil
+	case err != nil:
+		c.logger.Errorw("status change failed, skipped", "order_uid", change.OrderUID, "status", change.Status,
+			"partition", m.Partition, "offset", m.Offset, "err", err)
+		return change, nil
+	case order == nil:
+		c.logger.Warnw("status change for unknown order", "order_uid", change.OrderUID, "status", change.Status)
+		return change, nil
+	}
+
+	_, span = tracer.Start(ctx, "order.cache")
+	c.cache.PutInCache(order.OrderUID, *order)
+	c.cache.InvalidateCustomer(order.CustomerId)
+	span.End()
+
+	return change, nil
+}
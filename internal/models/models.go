@@ -1,22 +1,34 @@
 package models
 
-import "time"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 type Order struct {
-	OrderUID          string    `json:"order_uid" db:"order_uid"`
-	TrackNumber       string    `json:"track_number" db:"track_number"`
-	Entry             string    `json:"entry" db:"entry"`
-	Delivery          Delivery  `json:"delivery"`
-	Payment           Payment   `json:"payment"`
-	Items             []Item    `json:"items"`
-	Locale            string    `json:"locale" db:"locale"`
-	InternalSignature string    `json:"internal_signature" db:"internal_signature"`
-	CustomerId        string    `json:"customer_id" db:"customer_id"`
-	DeliveryService   string    `json:"delivery_service" db:"delivery_service"`
-	ShardKey          string    `json:"shardkey" db:"shardkey"`
-	SmId              int       `json:"sm_id" db:"sm_id"`
-	DateCreated       time.Time `json:"date_created" db:"date_created"`
-	OofShard          string    `json:"oof_shard" db:"oof_shard"`
+	OrderUID          string      `json:"order_uid" db:"order_uid"`
+	TrackNumber       string      `json:"track_number" db:"track_number"`
+	Entry             string      `json:"entry" db:"entry"`
+	Delivery          Delivery    `json:"delivery"`
+	Payment           Payment     `json:"payment"`
+	Items             []Item      `json:"items"`
+	Locale            string      `json:"locale" db:"locale"`
+	InternalSignature string      `json:"internal_signature" db:"internal_signature"`
+	CustomerId        string      `json:"customer_id" db:"customer_id"`
+	DeliveryService   string      `json:"delivery_service" db:"delivery_service"`
+	ShardKey          string      `json:"shardkey" db:"shardkey"`
+	SmId              int         `json:"sm_id" db:"sm_id"`
+	DateCreated       time.Time   `json:"date_created" db:"date_created"`
+	OofShard          string      `json:"oof_shard" db:"oof_shard"`
+	Status            OrderStatus `json:"status,omitempty" db:"status"` // changed by status changes only
+	Version           int64       `json:"-" db:"version"`
+
+	// PreviousCustomerId is set by a write that moved the order to another customer.
+	PreviousCustomerId string `json:"-" db:"-"`
 }
 
 type Delivery struct {
@@ -36,25 +48,309 @@ type Payment struct {
 	RequestId    string `json:"request_id" db:"request_id"`
 	Currency     string `json:"currency" db:"currency"`
 	Provider     string `json:"provider" db:"provider"`
-	Amount       int    `json:"amount" db:"amount"`
+	Amount       Money  `json:"amount" db:"amount"`
 	PaymentDt    int64  `json:"payment_dt" db:"payment_dt"`
 	Bank         string `json:"bank" db:"bank"`
-	DeliveryCost int    `json:"delivery_cost" db:"delivery_cost"`
-	GoodsTotal   int    `json:"goods_total" db:"goods_total"`
-	CustomFee    int    `json:"custom_fee" db:"custom_fee"`
+	DeliveryCost Money  `json:"delivery_cost" db:"delivery_cost"`
+	GoodsTotal   Money  `json:"goods_total" db:"goods_total"`
+	CustomFee    Money  `json:"custom_fee" db:"custom_fee"`
+
+	// ReportingAmount is Amount converted to ReportingCurrency at PaymentDt;
+	// both are empty until a rate for that date is known. Like the other
+	// amounts it also decodes from the object the API returns, so an order
+	// read from the API can be written back.
+	ReportingAmount   Money  `json:"reporting_amount,omitzero" db:"reporting_amount"`
+	ReportingCurrency string `json:"reporting_currency,omitempty" db:"reporting_currency"`
+}
+
+// Reporting returns the amount in the reporting currency, if converted.
+func (p Payment) Reporting() (Money, bool) {
+	if p.ReportingCurrency == "" {
+		return Money{}, false
+	}
+	return Money{Amount: p.ReportingAmount.Amount, Currency: p.ReportingCurrency}, true
+}
+
+// FillCurrency gives every amount of p without a currency the payment
+// currency.
+func (p *Payment) FillCurrency() {
+	for _, m := range []*Money{&p.Amount, &p.DeliveryCost, &p.GoodsTotal, &p.CustomFee} {
+		if m.Currency == "" {
+			m.Currency = p.Currency
+		}
+	}
 }
 
 type Item struct {
 	OrderUID    string `json:"order_uid" db:"order_uid"`
 	ChrtId      int    `json:"chrt_id" db:"chrt_id"`
 	TrackNumber string `json:"track_number" db:"track_number"`
-	Price       int    `json:"price" db:"price"`
+	Price       Money  `json:"price" db:"price"`
 	Rid         string `json:"rid" db:"rid"`
 	Name        string `json:"name" db:"name"`
 	Sale        int    `json:"sale" db:"sale"`
 	Size        string `json:"size" db:"size"`
-	TotalPrice  int    `json:"total_price" db:"total_price"`
+	TotalPrice  Money  `json:"total_price" db:"total_price"`
 	NmId        int    `json:"nm_id" db:"nm_id"`
 	Brand       string `json:"brand" db:"brand"`
 	Status      int    `json:"status" db:"status"`
+
+	FulfillmentStatus OrderStatus `json:"fulfillment_status,omitempty" db:"fulfillment_status"` // changed by status changes only
+	StatusChangedAt   time.Time   `json:"status_changed_at,omitzero" db:"status_changed_at"`
+}
+
+// UnmarshalJSON decodes an order and gives its amounts the payment currency.
+func (o *Order) UnmarshalJSON(b []byte) error {
+	type order Order
+	if err := json.Unmarshal(b, (*order)(o)); err != nil {
+		return err
+	}
+	o.FillCurrency()
+	return nil
+}
+
+// DecodeOrder decodes the next order from dec. Unlike dec.Decode(o) it keeps
+// dec.DisallowUnknownFields in effect, which UnmarshalJSON cannot see.
+func DecodeOrder(dec *json.Decoder, o *Order) error {
+	type order Order
+	if err := dec.Decode((*order)(o)); err != nil {
+		return err
+	}
+	o.FillCurrency()
+	return nil
+}
+
+// FillCurrency gives every amount of o without a currency the payment
+// currency, which all amounts of an order, item prices included, use.
+func (o *Order) FillCurrency() {
+	o.eachAmount(func(_ string, m *Money) {
+		if m.Currency == "" {
+			m.Currency = o.Payment.Currency
+		}
+	})
+}
+
+func (o *Order) eachAmount(fn func(path string, m *Money)) {
+	fn("payment.amount", &o.Payment.Amount)
+	fn("payment.delivery_cost", &o.Payment.DeliveryCost)
+	fn("payment.goods_total", &o.Payment.GoodsTotal)
+	fn("payment.custom_fee", &o.Payment.CustomFee)
+	for i := range o.Items {
+		fn(fmt.Sprintf("items[%d].price", i), &o.Items[i].Price)
+		fn(fmt.Sprintf("items[%d].total_price", i), &o.Items[i].TotalPrice)
+	}
+}
+
+func (o Order) Validate() error {
+	var missing []string
+	require := func(field, value string) {
+		if value == "" {
+			missing = append(missing, field)
+		}
+	}
+
+	require("order_uid", o.OrderUID)
+	require("track_number", o.TrackNumber)
+	require("entry", o.Entry)
+	require("locale", o.Locale)
+	require("customer_id", o.CustomerId)
+	require("delivery_service", o.DeliveryService)
+	require("shardkey", o.ShardKey)
+	require("oof_shard", o.OofShard)
+	if o.DateCreated.IsZero() {
+		missing = append(missing, "date_created")
+	}
+
+	require("delivery.name", o.Delivery.Name)
+	require("delivery.phone", o.Delivery.Phone)
+	require("delivery.city", o.Delivery.City)
+	require("delivery.address", o.Delivery.Address)
+
+	require("payment.transaction", o.Payment.Transaction)
+	require("payment.currency", o.Payment.Currency)
+	require("payment.provider", o.Payment.Provider)
+	require("payment.bank", o.Payment.Bank)
+
+	if len(o.Items) == 0 {
+		missing = append(missing, "items")
+	}
+	for i, it := range o.Items {
+		require(fmt.Sprintf("items[%d].rid", i), it.Rid)
+		require(fmt.Sprintf("items[%d].name", i), it.Name)
+		if it.Price.Amount < 0 || it.TotalPrice.Amount < 0 {
+			return fmt.Errorf("items[%d]: negative price", i)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required fields: %s", strings.Join(missing, ", "))
+	}
+	if !ValidCurrency(o.Payment.Currency) {
+		return fmt.Errorf("payment: bad currency %q, want an ISO 4217 code", o.Payment.Currency)
+	}
+	if o.Payment.Amount.Amount < 0 || o.Payment.GoodsTotal.Amount < 0 || o.Payment.DeliveryCost.Amount < 0 {
+		return fmt.Errorf("payment: negative amount")
+	}
+	var mismatch error
+	o.eachAmount(func(path string, m *Money) {
+		if mismatch == nil && m.Currency != "" && m.Currency != o.Payment.Currency {
+			mismatch = fmt.Errorf("%s: %w: %s, payment is in %s", path, ErrCurrencyMismatch, m.Currency, o.Payment.Currency)
+		}
+	})
+	return mismatch
+}
+
+const (
+	OrderCreated  = "order.created"
+	OrderUpdated  = "order.updated"
+	OrderDeleted  = "order.deleted"
+	OrderRestored = "order.restored"
+
+	OrderStatusChanged = "order.status_changed"
+)
+
+type OrderEvent struct {
+	ID          int64           `json:"event_id" db:"id"`
+	OrderUID    string          `json:"order_uid" db:"order_uid"`
+	Type        string          `json:"type" db:"event_type"`
+	Payload     json.RawMessage `json:"order,omitempty" db:"payload"`
+	CreatedAt   time.Time       `json:"occurred_at" db:"created_at"`
+	PublishedAt *time.Time      `json:"-" db:"published_at"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrOrderDeleted is returned for writes to a soft-deleted order; it has to
+// be restored first.
+var ErrOrderDeleted = errors.New("order is deleted")
+
+// AnyVersion as the expected version of a write lets it replace an order of
+// any version but not create one, like "If-Match: *".
+const AnyVersion int64 = -1
+
+// ConflictError is returned when a write expected a different row version
+// than the one stored. Actual is 0 if the order does not exist.
+type ConflictError struct {
+	OrderUID string
+	Expected int64
+	Actual   int64
+}
+
+func (e *ConflictError) Error() string {
+	if e.Expected == AnyVersion {
+		return fmt.Sprintf("order %s: version conflict: order does not exist", e.OrderUID)
+	}
+	return fmt.Sprintf("order %s: version conflict: expected %d, actual %d", e.OrderUID, e.Expected, e.Actual)
+}
+
+const (
+	SortByDate   = "date"
+	SortByAmount = "amount"
+)
+
+type OrderFilter struct {
+	CustomerID      string
+	TrackNumber     string
+	DeliveryService string
+	Locale          string
+	CreatedFrom     time.Time
+	CreatedTo       time.Time
+	Currency        string
+	Provider        string
+	Bank            string
+	AmountMin       *int64
+	AmountMax       *int64
+	ItemStatus      OrderStatus // orders with at least one item in this status
+
+	SortBy string
+	Asc    bool
+	Limit  int
+	Cursor string
+}
+
+type OrderPage struct {
+	Orders     []Order
+	NextCursor string
+}
+
+type LookupKey string
+
+const (
+	LookupTrackNumber LookupKey = "track_number"
+	LookupTransaction LookupKey = "transaction"
+	LookupChrtID      LookupKey = "chrt_id"
+	LookupRid         LookupKey = "rid"
+	LookupNmID        LookupKey = "nm_id"
+)
+
+type CustomerHistory struct {
+	CustomerID string
+	OrderCount int
+	TotalSpent []Money // one entry per currency
+	// TotalReporting sums the converted amounts, one entry per reporting
+	// currency; orders without a known rate are left out.
+	TotalReporting []Money
+	FirstOrderAt   time.Time
+	LastOrderAt    time.Time
+	Orders         []Order
+}
+
+type OrderVersion struct {
+	OrderUID   string          `db:"order_uid"`
+	Version    int             `db:"version"`
+	Operation  string          `db:"operation"`
+	Snapshot   json.RawMessage `db:"snapshot"`
+	Source     string          `db:"source"`
+	Actor      string          `db:"actor"`
+	RecordedAt time.Time       `db:"recorded_at"`
+}
+
+// Audit describes who caused a write and where it came from, e.g. a kafka offset.
+type Audit struct {
+	Actor  string
+	Source string
+}
+
+type auditKey struct{}
+
+func WithAudit(ctx context.Context, a Audit) context.Context {
+	return context.WithValue(ctx, auditKey{}, a)
+}
+
+func AuditFrom(ctx context.Context) Audit {
+	a, _ := ctx.Value(auditKey{}).(Audit)
+	return a
+}
+
+type primaryKey struct{}
+
+// WithPrimary forces reads made with ctx to go to the primary database
+// rather than a replica.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func PrimaryFrom(ctx context.Context) bool {
+	return ctx.Value(primaryKey{}) != nil
+}
+
+var ErrPartitionNotFound = errors.New("partition not found")
+
+// OrderPartition is a monthly partition of the orders table covering
+// [From, To). Archived partitions are detached and kept in a file at Path.
+type OrderPartition struct {
+	Name       string     `db:"partition_name"`
+	From       time.Time  `db:"range_from"`
+	To         time.Time  `db:"range_to"`
+	Orders     int64      `db:"orders"`
+	Path       string     `db:"path"`
+	ArchivedAt *time.Time `db:"archived_at"`
+}
+
+// SearchHit is an order found by full-text search. Highlights are the
+// matching delivery and item texts with matches wrapped in <mark></mark>.
+type SearchHit struct {
+	Order      Order
+	Rank       float64
+	Highlights []string
 }
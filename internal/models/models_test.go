@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeOrder(t *testing.T) {
+	// The payment as GET /order/{order_uid} returns it.
+	const payment = `"payment": {
+		"transaction": "t1", "request_id": "", "currency": "USD", "provider": "wbpay",
+		"amount": {"amount": 1817, "currency": "USD", "formatted": "18.17 USD"},
+		"payment_dt": 1637907727, "bank": "alpha",
+		"delivery_cost": {"amount": 1500, "currency": "USD", "formatted": "15.00 USD"},
+		"goods_total": 317, "custom_fee": 0,
+		"reporting_amount": {"amount": 184749, "currency": "RUB", "formatted": "1847.49 RUB"}
+	}`
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"api response", `{"order_uid": "o1", ` + payment + `}`, ""},
+		{"unknown field", `{"order_uid": "o1", "bogus": 1, ` + payment + `}`, `unknown field "bogus"`},
+		{"unknown payment field", `{"order_uid": "o1", "payment": {"bogus": 1}}`, `unknown field "bogus"`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dec := json.NewDecoder(strings.NewReader(tc.body))
+			dec.DisallowUnknownFields()
+			var o Order
+			err := DecodeOrder(dec, &o)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := o.Payment
+			if p.Amount != (Money{1817, "USD"}) || p.GoodsTotal != (Money{317, "USD"}) || p.ReportingAmount.Amount != 184749 {
+				t.Fatalf("payment = %+v", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrMoneyOverflow    = errors.New("money amount overflow")
+)
+
+// Money is an amount in minor units (cents, kopecks, ...) of an ISO 4217
+// currency. Order payloads and BIGINT columns carry just the amount, in the
+// minor units of payment.currency; Order.FillCurrency pairs them up.
+type Money struct {
+	Amount   int64  `json:"amount" db:"amount"`
+	Currency string `json:"currency" db:"currency"`
+}
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ValidCurrency reports whether code looks like an ISO 4217 alphabetic code.
+func ValidCurrency(code string) bool {
+	return currencyCode.MatchString(code)
+}
+
+// minorUnits lists ISO 4217 currencies whose minor unit is not 1/100.
+var minorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// MinorUnits returns the number of decimal places of currency.
+func MinorUnits(currency string) int {
+	if n, ok := minorUnits[currency]; ok {
+		return n
+	}
+	return 2
+}
+
+// Add sums two amounts of the same currency.
+func (m Money) Add(n Money) (Money, error) {
+	if m.Currency != n.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, n.Currency)
+	}
+	if (n.Amount > 0 && m.Amount > math.MaxInt64-n.Amount) || (n.Amount < 0 && m.Amount < math.MinInt64-n.Amount) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: m.Amount + n.Amount, Currency: m.Currency}, nil
+}
+
+// Decimal formats the amount in major units, e.g. 1817 USD as "18.17" and
+// 1817 JPY as "1817".
+func (m Money) Decimal() string {
+	digits := strconv.FormatUint(absInt64(m.Amount), 10)
+	sign := ""
+	if m.Amount < 0 {
+		sign = "-"
+	}
+	scale := MinorUnits(m.Currency)
+	if scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// MarshalJSON encodes m as its amount in minor units.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, m.Amount, 10), nil
+}
+
+// UnmarshalJSON accepts an amount in minor units or an API money object,
+// {"amount": 1817, "currency": "USD", "formatted": "18.17 USD"}.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		*m = Money{}
+		return json.Unmarshal(b, &m.Amount)
+	}
+	var v struct {
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*m = Money{Amount: v.Amount, Currency: v.Currency}
+	return nil
+}
+
+// Value stores the amount; the currency lives in payments.currency.
+func (m Money) Value() (driver.Value, error) {
+	return m.Amount, nil
+}
+
+// Scan reads an amount column, leaving the currency to FillCurrency.
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case int64:
+		m.Amount = v
+	case []byte:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return fmt.Errorf("%w: %s", ErrMoneyOverflow, v)
+		}
+		if err != nil {
+			return fmt.Errorf("money: %w", err)
+		}
+		m.Amount = n
+	case nil:
+		m.Amount = 0
+	default:
+		return fmt.Errorf("money: unsupported column type %T", src)
+	}
+	return nil
+}
+
+// String formats m as "18.17 USD".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+func absInt64(n int64) uint64 {
+	if n < 0 {
+		return uint64(-(n + 1)) + 1
+	}
+	return uint64(n)
+}
+
+// ExchangeRate is the price of one unit of Currency in Quote, valid from
+// EffectiveFrom until the next rate of the same pair.
+type ExchangeRate struct {
+	Currency      string    `db:"currency"`
+	Quote         string    `db:"quote"`
+	EffectiveFrom time.Time `db:"effective_from"`
+	Rate          float64   `db:"rate"`
+}
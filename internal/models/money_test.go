@@ -0,0 +1,131 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMoneyDecimal(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{Money{1817, "USD"}, "18.17"},
+		{Money{5, "USD"}, "0.05"},
+		{Money{0, "USD"}, "0.00"},
+		{Money{-1817, "USD"}, "-18.17"},
+		{Money{-5, "RUB"}, "-0.05"},
+		{Money{1817, "JPY"}, "1817"},
+		{Money{-1817, "JPY"}, "-1817"},
+		{Money{0, "JPY"}, "0"},
+		{Money{1817, "KWD"}, "1.817"},
+		{Money{7, "KWD"}, "0.007"},
+		{Money{math.MaxInt64, "USD"}, "92233720368547758.07"},
+		{Money{math.MinInt64, "USD"}, "-92233720368547758.08"},
+		{Money{math.MinInt64, "JPY"}, "-9223372036854775808"},
+	}
+	for _, tc := range tests {
+		if got := tc.m.Decimal(); got != tc.want {
+			t.Errorf("%d %s: Decimal() = %q, want %q", tc.m.Amount, tc.m.Currency, got, tc.want)
+		}
+	}
+	if got := (Money{-1817, "USD"}).String(); got != "-18.17 USD" {
+		t.Errorf("String() = %q, want -18.17 USD", got)
+	}
+}
+
+func TestMoneyAdd(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    Money
+		want    Money
+		wantErr error
+	}{
+		{"sum", Money{100, "USD"}, Money{250, "USD"}, Money{350, "USD"}, nil},
+		{"negative", Money{100, "USD"}, Money{-250, "USD"}, Money{-150, "USD"}, nil},
+		{"up to max", Money{math.MaxInt64 - 1, "USD"}, Money{1, "USD"}, Money{math.MaxInt64, "USD"}, nil},
+		{"down to min", Money{math.MinInt64 + 1, "USD"}, Money{-1, "USD"}, Money{math.MinInt64, "USD"}, nil},
+		{"over max", Money{math.MaxInt64, "USD"}, Money{1, "USD"}, Money{}, ErrMoneyOverflow},
+		{"halves over max", Money{math.MaxInt64/2 + 1, "USD"}, Money{math.MaxInt64/2 + 1, "USD"}, Money{}, ErrMoneyOverflow},
+		{"under min", Money{math.MinInt64, "USD"}, Money{-1, "USD"}, Money{}, ErrMoneyOverflow},
+		{"currencies", Money{100, "USD"}, Money{100, "EUR"}, Money{}, ErrCurrencyMismatch},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.a.Add(tc.b)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Fatalf("sum = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{`1817`, Money{1817, ""}},
+		{`-1817`, Money{-1817, ""}},
+		{`0`, Money{}},
+		{`{"amount": 1817, "currency": "USD", "formatted": "18.17 USD"}`, Money{1817, "USD"}},
+		{` {"amount": -5, "currency": "JPY"}`, Money{-5, "JPY"}},
+		{`9223372036854775807`, Money{math.MaxInt64, ""}},
+	}
+	for _, tc := range tests {
+		var m Money
+		if err := json.Unmarshal([]byte(tc.in), &m); err != nil || m != tc.want {
+			t.Errorf("unmarshal %s = %v, %v; want %v", tc.in, m, err, tc.want)
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back Money
+		if err = json.Unmarshal(b, &back); err != nil || back.Amount != m.Amount {
+			t.Errorf("%v marshals to %s, which reads back as %v, %v", m, b, back, err)
+		}
+	}
+
+	for _, in := range []string{`"1817"`, `18.17`, `9223372036854775808`, `{"amount": "1"}`} {
+		var m Money
+		if err := json.Unmarshal([]byte(in), &m); err == nil {
+			t.Errorf("unmarshal %s = %v, want an error", in, m)
+		}
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		src     any
+		want    int64
+		wantErr error
+	}{
+		{int64(1817), 1817, nil},
+		{int64(-5), -5, nil},
+		{[]byte("9223372036854775807"), math.MaxInt64, nil},
+		{nil, 0, nil},
+		{[]byte("9223372036854775808"), 0, ErrMoneyOverflow},
+		{[]byte("-9223372036854775809"), 0, ErrMoneyOverflow},
+	}
+	for _, tc := range tests {
+		m := Money{Amount: 42}
+		err := m.Scan(tc.src)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("scan %v: err = %v, want %v", tc.src, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr == nil && m.Amount != tc.want {
+			t.Errorf("scan %v = %d, want %d", tc.src, m.Amount, tc.want)
+		}
+	}
+	var m Money
+	if err := m.Scan("18.17"); err == nil {
+		t.Error("scan of a string succeeded")
+	}
+}
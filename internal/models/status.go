@@ -0,0 +1,179 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// OrderStatus is a stage of the order lifecycle:
+//
+//	created -> paid -> assembling -> shipped -> delivered -> returned
+//
+// An order can be cancelled until it is shipped and returned once shipped.
+type OrderStatus string
+
+const (
+	StatusCreated    OrderStatus = "created"
+	StatusPaid       OrderStatus = "paid"
+	StatusAssembling OrderStatus = "assembling"
+	StatusShipped    OrderStatus = "shipped"
+	StatusDelivered  OrderStatus = "delivered"
+	StatusCancelled  OrderStatus = "cancelled"
+	StatusReturned   OrderStatus = "returned"
+)
+
+var statusTransitions = map[OrderStatus][]OrderStatus{
+	StatusCreated:    {StatusPaid, StatusCancelled},
+	StatusPaid:       {StatusAssembling, StatusCancelled},
+	StatusAssembling: {StatusShipped, StatusCancelled},
+	StatusShipped:    {StatusDelivered, StatusReturned},
+	StatusDelivered:  {StatusReturned},
+	StatusCancelled:  nil,
+	StatusReturned:   nil,
+}
+
+// statusRank orders the statuses by how far the goods have gone; cancelled
+// items are left out when the order status is derived.
+var statusRank = map[OrderStatus]int{
+	StatusCreated:    0,
+	StatusPaid:       1,
+	StatusAssembling: 2,
+	StatusShipped:    3,
+	StatusDelivered:  4,
+	StatusReturned:   5,
+}
+
+// ReasonItemsChanged is recorded when an order write adds or removes items
+// and so moves the order status derived from them.
+const ReasonItemsChanged = "items changed"
+
+var (
+	ErrInvalidStatus = errors.New("invalid order status")
+	ErrItemNotFound  = errors.New("order item not found")
+)
+
+func (s OrderStatus) Valid() bool {
+	_, ok := statusTransitions[s]
+	return ok
+}
+
+// Next lists the statuses s may change to; it is empty for final statuses.
+func (s OrderStatus) Next() []OrderStatus {
+	return statusTransitions[s]
+}
+
+func (s OrderStatus) CanBecome(to OrderStatus) bool {
+	for _, n := range statusTransitions[s] {
+		if n == to {
+			return true
+		}
+	}
+	return false
+}
+
+// DeriveStatus returns the order status implied by the statuses of its
+// items: the least advanced status of the items that are not cancelled, or
+// cancelled if all of them are. It returns false for an order without items.
+func DeriveStatus(items []Item) (OrderStatus, bool) {
+	if len(items) == 0 {
+		return "", false
+	}
+	derived := StatusCancelled
+	for _, it := range items {
+		s := it.FulfillmentStatus
+		if s == "" {
+			s = StatusCreated
+		}
+		if s == StatusCancelled {
+			continue
+		}
+		if derived == StatusCancelled || statusRank[s] < statusRank[derived] {
+			derived = s
+		}
+	}
+	return derived, true
+}
+
+// StatusTransitionError reports a status change the lifecycle does not
+// allow, for the whole order or for one of its items. Derived is set when
+// items that cannot move would leave the order at that status instead.
+type StatusTransitionError struct {
+	OrderUID string
+	Item     *ItemRef
+	From     OrderStatus
+	To       OrderStatus
+	Derived  OrderStatus
+}
+
+func (e *StatusTransitionError) Error() string {
+	if e.Item != nil {
+		return fmt.Sprintf("order %s item %s: status cannot change from %s to %s", e.OrderUID, e.Item, e.From, e.To)
+	}
+	if e.Derived != "" {
+		return fmt.Sprintf("order %s: status cannot change from %s to %s: items that cannot move would leave it %s",
+			e.OrderUID, e.From, e.To, e.Derived)
+	}
+	return fmt.Sprintf("order %s: status cannot change from %s to %s", e.OrderUID, e.From, e.To)
+}
+
+// ItemRef identifies an item of an order by its natural key.
+type ItemRef struct {
+	ChrtId int    `json:"chrt_id" db:"chrt_id"`
+	Rid    string `json:"rid" db:"rid"`
+}
+
+func (r ItemRef) String() string {
+	return fmt.Sprintf("%d/%s", r.ChrtId, r.Rid)
+}
+
+func (it Item) Ref() ItemRef {
+	return ItemRef{ChrtId: it.ChrtId, Rid: it.Rid}
+}
+
+// StatusChange asks to move an order to Status, as received from Kafka or
+// the HTTP API. With Items set only those items change and the order status
+// follows them. A zero ChangedAt means now.
+type StatusChange struct {
+	OrderUID  string      `json:"order_uid"`
+	Items     []ItemRef   `json:"items,omitempty"`
+	Status    OrderStatus `json:"status"`
+	Reason    string      `json:"reason,omitempty"`
+	ChangedAt time.Time   `json:"changed_at"`
+}
+
+// Fulfillment is the current status of an order and of each of its items.
+type Fulfillment struct {
+	OrderUID string      `db:"order_uid"`
+	Status   OrderStatus `db:"status"`
+	Version  int64       `db:"version"`
+	Items    []Item
+}
+
+// StatusUpdate is a checked status change ready to be stored: the order
+// moves to Order.Status, which may be its current status, and each of Items
+// to its Status. It applies only while the order is still at Version.
+type StatusUpdate struct {
+	Version int64
+	Order   StatusRecord
+	Items   []ItemStatusRecord
+}
+
+// StatusRecord is one entry of an order's status history. From is empty for
+// the initial status.
+type StatusRecord struct {
+	OrderUID   string      `json:"order_uid" db:"order_uid"`
+	From       OrderStatus `json:"from,omitempty" db:"from_status"`
+	Status     OrderStatus `json:"status" db:"status"`
+	Reason     string      `json:"reason,omitempty" db:"reason"`
+	ChangedAt  time.Time   `json:"changed_at" db:"changed_at"`
+	RecordedAt time.Time   `json:"recorded_at" db:"recorded_at"`
+	Source     string      `json:"source" db:"source"`
+	Actor      string      `json:"actor" db:"actor"`
+}
+
+// ItemStatusRecord is one entry of an item's status history.
+type ItemStatusRecord struct {
+	ItemRef
+	StatusRecord
+}
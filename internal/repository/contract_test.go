@@ -0,0 +1,494 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wb/internal/generator"
+	"wb/internal/models"
+)
+
+// contractRepo is what OrderRepo and MemoryOrderRepo both implement. The
+// contract tests run the same behaviours against each, so demo mode keeps
+// behaving like Postgres.
+type contractRepo interface {
+	Get(ctx context.Context, orderUID string) (*models.Order, error)
+	Upsert(ctx context.Context, o *models.Order, expectedVersion int64) (int64, error)
+	BulkUpsert(ctx context.Context, orders []models.Order) (int, error)
+	Delete(ctx context.Context, orderUID string) (string, bool, error)
+	Restore(ctx context.Context, orderUID string) (*models.Order, error)
+	Purge(ctx context.Context, olderThan time.Duration) (int64, error)
+	List(ctx context.Context, f models.OrderFilter) (*models.OrderPage, error)
+	Lookup(ctx context.Context, key models.LookupKey, value string) ([]models.Order, error)
+	Search(ctx context.Context, q string, limit int) ([]models.SearchHit, error)
+	CustomerHistory(ctx context.Context, customerID string) (*models.CustomerHistory, error)
+	Fulfillment(ctx context.Context, orderUID string) (*models.Fulfillment, error)
+	SetStatus(ctx context.Context, u models.StatusUpdate) (*models.Order, error)
+	StatusHistory(ctx context.Context, orderUID string) ([]models.StatusRecord, error)
+	SetReporting(ctx context.Context, payments []models.Payment) (map[string]string, error)
+}
+
+var contractTests = []struct {
+	name string
+	run  func(t *testing.T, repo contractRepo, gen *generator.Generator)
+}{
+	{"version and conflicts", testContractVersions},
+	{"soft delete, restore and purge", testContractSoftDelete},
+	{"duplicate item keys", testContractDuplicateItems},
+	{"keyset pagination", testContractPagination},
+	{"lookup", testContractLookup},
+	{"customer history", testContractCustomerHistory},
+	{"search", testContractSearch},
+	{"status transitions", testContractStatus},
+	{"reporting amounts", testContractReporting},
+}
+
+func TestMemoryOrderRepoContract(t *testing.T) {
+	runContract(t, func() contractRepo { return NewMemoryOrderRepo() })
+}
+
+// TestOrderRepoContract needs PG_DSN, see testDB. Every run writes orders
+// with fresh uids and customers, so it can share a database with others.
+func TestOrderRepoContract(t *testing.T) {
+	db := testDB(t)
+	runContract(t, func() contractRepo { return newTestOrderRepo(db) })
+}
+
+func runContract(t *testing.T, newRepo func() contractRepo) {
+	for _, tc := range contractTests {
+		t.Run(tc.name, func(t *testing.T) {
+			// date_created is a TIMESTAMP without time zone: it keeps the
+			// wall clock of a UTC time to the microsecond and reads back in
+			// UTC. Whole seconds in UTC compare equal after the round trip.
+			start := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+			tc.run(t, newRepo(), generator.New(time.Now().UnixNano(), start))
+		})
+	}
+}
+
+func upsert(t *testing.T, repo contractRepo, o *models.Order, expectedVersion int64) int64 {
+	t.Helper()
+	v, err := repo.Upsert(context.Background(), o, expectedVersion)
+	if err != nil {
+		t.Fatalf("upsert %s: %v", o.OrderUID, err)
+	}
+	return v
+}
+
+func mustGet(t *testing.T, repo contractRepo, orderUID string) *models.Order {
+	t.Helper()
+	o, err := repo.Get(context.Background(), orderUID)
+	if err != nil {
+		t.Fatalf("get %s: %v", orderUID, err)
+	}
+	return o
+}
+
+// uniqueWord turns the order uid into a word of letters only, so that it is
+// a single search token nothing else in the database matches.
+func uniqueWord(orderUID string) string {
+	return "zq" + strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return 'g' + r - '0'
+		}
+		return r
+	}, orderUID)
+}
+
+func uids(orders []models.Order) []string {
+	out := make([]string, len(orders))
+	for i, o := range orders {
+		out[i] = o.OrderUID
+	}
+	return out
+}
+
+func contains(orders []models.Order, orderUID string) bool {
+	for _, o := range orders {
+		if o.OrderUID == orderUID {
+			return true
+		}
+	}
+	return false
+}
+
+func testContractVersions(t *testing.T, repo contractRepo, gen *generator.Generator) {
+	ctx := context.Background()
+	o := gen.Order(2)
+	if v := upsert(t, repo, &o, 0); v != 1 || o.Version != 1 {
+		t.Fatalf("created version = %d, order version %d, want 1", v, o.Version)
+	}
+	if v := upsert(t, repo, &o, 1); v != 2 {
+		t.Fatalf("updated version = %d, want 2", v)
+	}
+
+	var conflict *models.ConflictError
+	_, err := repo.Upsert(ctx, &o, 1)
+	if !errors.As(err, &conflict) || conflict.Expected != 1 || conflict.Actual != 2 {
+		t.Fatalf("stale write: err = %v, want a conflict expecting 1 with 2 stored", err)
+	}
+
+	missing := gen.Order(1)
+	_, err = repo.Upsert(ctx, &missing, 3)
+	if !errors.As(err, &conflict) || conflict.Expected != 3 || conflict.Actual != 0 {
+		t.Fatalf("versioned write of a new order: err = %v, want a conflict with nothing stored", err)
+	}
+	_, err = repo.Upsert(ctx, &missing, models.AnyVersion)
+	if !errors.As(err, &conflict) || conflict.Actual != 0 {
+		t.Fatalf("write of a new order with any version: err = %v, want a conflict with nothing stored", err)
+	}
+	if got := mustGet(t, repo, missing.OrderUID); got != nil {
+		t.Fatalf("rejected order %s was stored", missing.OrderUID)
+	}
+	if v := upsert(t, repo, &o, models.AnyVersion); v != 3 {
+		t.Fatalf("version after a write with any version = %d, want 3", v)
+	}
+	if got := mustGet(t, repo, o.OrderUID); got == nil || got.Version != 3 {
+		t.Fatalf("stored order = %+v, want version 3", got)
+	}
+}
+
+func testContractSoftDelete(t *testing.T, repo contractRepo, gen *generator.Generator) {
+	ctx := context.Background()
+	o := gen.Order(2)
+	upsert(t, repo, &o, 0)
+
+	customerID, ok, err := repo.Delete(ctx, o.OrderUID)
+	if err != nil || !ok || customerID != o.CustomerId {
+		t.Fatalf("delete = %q, %v, %v; want %q, true, nil", customerID, ok, err, o.CustomerId)
+	}
+	if got := mustGet(t, repo, o.OrderUID); got != nil {
+		t.Fatal("deleted order is still readable")
+	}
+	if _, ok, err = repo.Delete(ctx, o.OrderUID); ok || err != nil {
+		t.Fatalf("second delete = %v, %v; want false, nil", ok, err)
+	}
+	if _, err = repo.Upsert(ctx, &o, 0); !errors.Is(err, models.ErrOrderDeleted) {
+		t.Fatalf("write to a deleted order: err = %v, want %v", err, models.ErrOrderDeleted)
+	}
+
+	restored, err := repo.Restore(ctx, o.OrderUID)
+	if err != nil || restored == nil {
+		t.Fatalf("restore = %v, %v", restored, err)
+	}
+	if restored.Version != 3 {
+		t.Fatalf("restored version = %d, want 3 after create, delete and restore", restored.Version)
+	}
+	if again, err := repo.Restore(ctx, o.OrderUID); again != nil || err != nil {
+		t.Fatalf("restore of a live order = %v, %v; want nil, nil", again, err)
+	}
+	if got := mustGet(t, repo, o.OrderUID); got == nil || len(got.Items) != 2 {
+		t.Fatalf("restored order = %+v, want it with its 2 items", got)
+	}
+
+	if _, _, err = repo.Delete(ctx, o.OrderUID); err != nil {
+		t.Fatal(err)
+	}
+	n, err := repo.Purge(ctx, 0)
+	if err != nil || n < 1 {
+		t.Fatalf("purge = %d, %v; want at least the deleted order", n, err)
+	}
+	if restored, err = repo.Restore(ctx, o.OrderUID); restored != nil || err != nil {
+		t.Fatalf("restore after purge = %v, %v; want nil, nil", restored, err)
+	}
+}
+
+func testContractDuplicateItems(t *testing.T, repo contractRepo, gen *generator.Generator) {
+	ctx := context.Background()
+	withDuplicate := func() models.Order {
+		o := gen.Order(2)
+		dup := o.Items[0]
+		dup.Name = "Replaced"
+		o.Items = append(o.Items, dup)
+		return o
+	}
+	check := func(what string, o *models.Order, second string) {
+		t.Helper()
+		if len(o.Items) != 2 || o.Items[0].Name != "Replaced" || o.Items[1].Rid != second {
+			t.Fatalf("%s: items = %+v, want the repeated key replacing the first item in place", what, o.Items)
+		}
+	}
+
+	o := withDuplicate()
+	second := o.Items[1].Rid
+	upsert(t, repo, &o, 0)
+	check("upserted", &o, second)
+	check("stored", mustGet(t, repo, o.OrderUID), second)
+
+	b := withDuplicate()
+	second = b.Items[1].Rid
+	if n, err := repo.BulkUpsert(ctx, []models.Order{b}); err != nil || n != 1 {
+		t.Fatalf("bulk upsert = %d, %v", n, err)
+	}
+	check("bulk stored", mustGet(t, repo, b.OrderUID), second)
+}
+
+func testContractPagination(t *testing.T, repo contractRepo, gen *generator.Generator) {
+	ctx := context.Background()
+	customerID := "contract-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	var orders []models.Order
+	for i := 0; i < 5; i++ {
+		o := gen.Order(1)
+		o.CustomerId = customerID
+		upsert(t, repo, &o, 0)
+		orders = append(orders, o)
+	}
+	newestFirst(orders)
+	want := strings.Join(uids(orders), " ")
+
+	var got []string
+	f := models.OrderFilter{CustomerID: customerID, Limit: 2}
+	for pages := 1; ; pages++ {
+		page, err := repo.List(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, uids(page.Orders)...)
+		if page.NextCursor == "" {
+			if pages != 3 {
+				t.Fatalf("got %d pages of 2 for 5 orders, want 3", pages)
+			}
+			break
+		}
+		f.Cursor = page.NextCursor
+	}
+	if strings.Join(got, " ") != want {
+		t.Fatalf("pages = %v, want %v", got, want)
+	}
+
+	f = models.OrderFilter{CustomerID: customerID, Limit: 2, Asc: true}
+	page, err := repo.List(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := uids(page.Orders); len(first) != 2 || first[0] != orders[4].OrderUID || first[1] != orders[3].OrderUID {
+		t.Fatalf("first ascending page = %v, want the two oldest orders", first)
+	}
+	if _, err = repo.List(ctx, models.OrderFilter{Cursor: "not a cursor"}); !errors.Is(err, models.ErrInvalidCursor) {
+		t.Fatalf("bad cursor: err = %v, want %v", err, models.ErrInvalidCursor)
+	}
+}
+
+func testContractLookup(t *testing.T, repo contractRepo, gen *generator.Generator) {
+	ctx := context.Background()
+	o := gen.Order(2)
+	upsert(t, repo, &o, 0)
+
+	keys := map[models.LookupKey]string{
+		models.LookupTrackNumber: o.TrackNumber,
+		models.LookupTransaction: o.Payment.Transaction,
+		models.LookupChrtID:      strconv.Itoa(o.Items[1].ChrtId),
+		models.LookupRid:         o.Items[1].Rid,
+	}
+	for key, value := range keys {
+		found, err := repo.Lookup(ctx, key, value)
+		if err != nil {
+			t.Fatalf("lookup by %s: %v", key, err)
+		}
+		if !contains(found, o.OrderUID) {
+			t.Fatalf("lookup by %s %s = %v, want %s among them", key, value, uids(found), o.OrderUID)
+		}
+	}
+	if _, err := repo.Lookup(ctx, "color", "red"); err == nil {
+		t.Fatal("lookup by an unknown key succeeded")
+	}
+
+	if _, _, err := repo.Delete(ctx, o.OrderUID); err != nil {
+		t.Fatal(err)
+	}
+	found, err := repo.Lookup(ctx, models.LookupRid, o.Items[1].Rid)
+	if err != nil || contains(found, o.OrderUID) {
+		t.Fatalf("lookup of a deleted order = %v, %v; want it left out", uids(found), err)
+	}
+}
+
+func testContractCustomerHistory(t *testing.T, repo contractRepo, gen *generator.Generator) {
+	ctx := context.Background()
+	customerID := "contract-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	pay := func(currency string, amount int64) models.Order {
+		o := gen.Order(1)
+		o.CustomerId = customerID
+		o.Payment.Currency = currency
+		o.Payment.Amount = models.Money{Amount: amount, Currency: currency}
+		upsert(t, repo, &o, 0)
+		return o
+	}
+	first := pay("USD", 1000)
+	pay("EUR", 700)
+	last := pay("USD", 2500)
+
+	h, err := repo.CustomerHistory(ctx, customerID)
+	if err != nil || h == nil {
+		t.Fatalf("customer history = %v, %v", h, err)
+	}
+	want := []models.Money{{Amount: 700, Currency: "EUR"}, {Amount: 3500, Currency: "USD"}}
+	if h.OrderCount != 3 || len(h.TotalSpent) != 2 || h.TotalSpent[0] != want[0] || h.TotalSpent[1] != want[1] {
+		t.Fatalf("history = %d orders spending %v, want 3 spending %v", h.OrderCount, h.TotalSpent, want)
+	}
+	if !h.FirstOrderAt.Equal(first.DateCreated) || !h.LastOrderAt.Equal(last.DateCreated) {
+		t.Fatalf("history spans %v - %v, want %v - %v", h.FirstOrderAt, h.LastOrderAt, first.DateCreated, last.DateCreated)
+	}
+	if len(h.Orders) != 3 || h.Orders[0].OrderUID != last.OrderUID {
+		t.Fatalf("history orders = %v, want 3 newest first", uids(h.Orders))
+	}
+	if h, err = repo.CustomerHistory(ctx, customerID+"-none"); h != nil || err != nil {
+		t.Fatalf("history of an unknown customer = %v, %v; want nil, nil", h, err)
+	}
+
+	customerID += "-overflow"
+	pay("USD", math.MaxInt64/2+1)
+	pay("USD", math.MaxInt64/2+1)
+	if _, err = repo.CustomerHistory(ctx, customerID); !errors.Is(err, models.ErrMoneyOverflow) {
+		t.Fatalf("overflowing total: err = %v, want %v", err, models.ErrMoneyOverflow)
+	}
+}
+
+func testContractSearch(t *testing.T, repo contractRepo, gen *generator.Generator) {
+	ctx := context.Background()
+	o := gen.Order(2)
+	name, brand := uniqueWord(o.OrderUID), uniqueWord(o.OrderUID)+"brand"
+	o.Delivery.Name = "Contract " + name
+	o.Items[1].Brand = brand
+	upsert(t, repo, &o, 0)
+
+	other := gen.Order(1)
+	other.Delivery.Name = "Contract " + name
+	upsert(t, repo, &other, 0)
+
+	search := func(q string) []string {
+		t.Helper()
+		hits, err := repo.Search(ctx, q, 10)
+		if err != nil {
+			t.Fatalf("search %q: %v", q, err)
+		}
+		out := make([]string, len(hits))
+		for i, h := range hits {
+			out[i] = h.Order.OrderUID
+		}
+		return out
+	}
+
+	// The words match the delivery and an item of o, but only the delivery of other.
+	if got := search(name + " " + brand); len(got) != 1 || got[0] != o.OrderUID {
+		t.Fatalf("search across delivery and items = %v, want only %s", got, o.OrderUID)
+	}
+	if got := search(name[:len(name)-2]); len(got) != 2 {
+		t.Fatalf("prefix search = %v, want both orders", got)
+	}
+	if got := search("!!"); len(got) != 0 {
+		t.Fatalf("search without words = %v, want nothing", got)
+	}
+
+	if _, _, err := repo.Delete(ctx, o.OrderUID); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(name + " " + brand); len(got) != 0 {
+		t.Fatalf("search after delete = %v, want nothing", got)
+	}
+}
+
+func testContractStatus(t *testing.T, repo contractRepo, gen *generator.Generator) {
+	ctx := context.Background()
+	o := gen.Order(2)
+	upsert(t, repo, &o, 0)
+
+	f, err := repo.Fulfillment(ctx, o.OrderUID)
+	if err != nil || f == nil {
+		t.Fatalf("fulfillment = %v, %v", f, err)
+	}
+	if f.Status != models.StatusCreated || f.Version != 1 || len(f.Items) != 2 {
+		t.Fatalf("fulfillment = %s at version %d with %d items, want created at 1 with 2", f.Status, f.Version, len(f.Items))
+	}
+
+	at := time.Now().Truncate(time.Second)
+	record := func(from, to models.OrderStatus) models.StatusRecord {
+		return models.StatusRecord{OrderUID: o.OrderUID, From: from, Status: to, Reason: "contract", ChangedAt: at}
+	}
+	u := models.StatusUpdate{Version: f.Version, Order: record(models.StatusCreated, models.StatusPaid)}
+	for _, it := range f.Items {
+		u.Items = append(u.Items, models.ItemStatusRecord{
+			ItemRef:      it.Ref(),
+			StatusRecord: record(it.FulfillmentStatus, models.StatusPaid),
+		})
+	}
+	changed, err := repo.SetStatus(ctx, u)
+	if err != nil || changed == nil {
+		t.Fatalf("set status = %v, %v", changed, err)
+	}
+	if changed.Status != models.StatusPaid || changed.Version != 2 {
+		t.Fatalf("changed order is %s at version %d, want paid at 2", changed.Status, changed.Version)
+	}
+	for _, it := range changed.Items {
+		if it.FulfillmentStatus != models.StatusPaid {
+			t.Fatalf("item %s is %s, want paid", it.Ref(), it.FulfillmentStatus)
+		}
+	}
+	if stale, err := repo.SetStatus(ctx, u); stale != nil || err != nil {
+		t.Fatalf("set status at a stale version = %v, %v; want nil, nil", stale, err)
+	}
+
+	// A new item starts as created and takes the order back with it;
+	// removing it again lets the order follow the paid items.
+	paid := changed.Items
+	o = *changed
+	o.Items = append(append([]models.Item(nil), paid...), gen.Order(1).Items[0])
+	upsert(t, repo, &o, 0)
+	if o.Status != models.StatusCreated {
+		t.Fatalf("order with a new item is %s, want created", o.Status)
+	}
+	o.Items = append([]models.Item(nil), paid...)
+	upsert(t, repo, &o, 0)
+	if got := mustGet(t, repo, o.OrderUID); got.Status != models.StatusPaid {
+		t.Fatalf("order without the new item is %s, want paid", got.Status)
+	}
+
+	history, err := repo.StatusHistory(ctx, o.OrderUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rec := range history {
+		got = append(got, string(rec.From)+">"+string(rec.Status)+":"+rec.Reason)
+	}
+	want := []string{
+		">created:",
+		"created>paid:contract",
+		"paid>created:" + models.ReasonItemsChanged,
+		"created>paid:" + models.ReasonItemsChanged,
+	}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("status history = %v, want %v", got, want)
+	}
+}
+
+func testContractReporting(t *testing.T, repo contractRepo, gen *generator.Generator) {
+	ctx := context.Background()
+	o := gen.Order(1)
+	upsert(t, repo, &o, 0)
+
+	p := o.Payment
+	p.ReportingAmount, p.ReportingCurrency = models.Money{Amount: 12345, Currency: "RUB"}, "RUB"
+	customers, err := repo.SetReporting(ctx, []models.Payment{p})
+	if err != nil || customers[o.OrderUID] != o.CustomerId {
+		t.Fatalf("set reporting = %v, %v; want the order of %s", customers, err, o.CustomerId)
+	}
+	if got, _ := mustGet(t, repo, o.OrderUID).Payment.Reporting(); got != (models.Money{Amount: 12345, Currency: "RUB"}) {
+		t.Fatalf("stored reporting amount = %v, want 123.45 RUB", got)
+	}
+
+	// The payment changed after it was read: the stale conversion is dropped.
+	stale := p
+	stale.ReportingAmount.Amount = 1
+	o.Payment.Amount.Amount++
+	upsert(t, repo, &o, 0)
+	if customers, err = repo.SetReporting(ctx, []models.Payment{stale}); err != nil || len(customers) != 0 {
+		t.Fatalf("set reporting of a changed payment = %v, %v; want nothing changed", customers, err)
+	}
+	if got, _ := mustGet(t, repo, o.OrderUID).Payment.Reporting(); got.Amount == 1 {
+		t.Fatal("stale reporting amount overwrote the changed payment")
+	}
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+	"wb/internal/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+type HistoryRepo struct {
+	db *sqlx.DB
+}
+
+func NewHistoryRepo(db *sqlx.DB) *HistoryRepo {
+	return &HistoryRepo{db: db}
+}
+
+// InsertTx appends the next version of an order. Writers of the same order
+// are serialized by the row lock on orders, so versions do not collide.
+func (r *HistoryRepo) InsertTx(ctx context.Context, tx *sqlx.Tx, orderUID, operation string, snapshot any) error {
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.WithMessage(err, "marshal order snapshot")
+	}
+
+	a := models.AuditFrom(ctx)
+	const q = `
+		INSERT INTO order_history (order_uid, version, operation, snapshot, source, actor)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5
+		FROM order_history
+		WHERE order_uid = $1
+	`
+	if _, err := tx.ExecContext(ctx, q, orderUID, operation, b, a.Source, a.Actor); err != nil {
+		return errors.WithMessage(err, "insert order history (tx)")
+	}
+	return nil
+}
+
+func (r *HistoryRepo) List(ctx context.Context, orderUID string) ([]models.OrderVersion, error) {
+	const q = `
+		SELECT order_uid, version, operation, snapshot, source, actor, recorded_at
+		FROM order_history
+		WHERE order_uid = $1
+		ORDER BY version
+	`
+	var out []models.OrderVersion
+	if err := r.db.SelectContext(ctx, &out, q, orderUID); err != nil {
+		return nil, errors.WithMessage(err, "select order history")
+	}
+	return out, nil
+}
+
+// AsOf returns the version of an order that was current at t, or nil if the
+// order did not exist (or was deleted) at that moment.
+func (r *HistoryRepo) AsOf(ctx context.Context, orderUID string, t time.Time) (*models.OrderVersion, error) {
+	const q = `
+		SELECT order_uid, version, operation, snapshot, source, actor, recorded_at
+		FROM order_history
+		WHERE order_uid = $1 AND recorded_at <= $2
+		ORDER BY version DESC
+		LIMIT 1
+	`
+	var out models.OrderVersion
+	if err := r.db.GetContext(ctx, &out, q, orderUID, t); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.WithMessage(err, "select order version")
+	}
+	if out.Operation == models.OrderDeleted {
+		return nil, nil
+	}
+	return &out, nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
+	"time"
 	"wb/internal/models"
 )
 
@@ -22,15 +24,12 @@ func (r *ItemRepo) UpsertTx(ctx context.Context, tx *sqlx.Tx, it models.Item) (i
 	const q = `
 		INSERT INTO items (
 			order_uid, chrt_id, track_number, price, rid, name, sale, size,
-			total_price, nm_id, brand, status
+			total_price, nm_id, brand, status, fulfillment_status, status_changed_at
 		)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-		ON CONFLICT (id) DO UPDATE SET
-			order_uid=EXCLUDED.order_uid,
-			chrt_id=EXCLUDED.chrt_id,
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
+		ON CONFLICT (order_uid, chrt_id, rid) DO UPDATE SET
 			track_number=EXCLUDED.track_number,
 			price=EXCLUDED.price,
-			rid=EXCLUDED.rid,
 			name=EXCLUDED.name,
 			sale=EXCLUDED.sale,
 			size=EXCLUDED.size,
@@ -44,16 +43,101 @@ func (r *ItemRepo) UpsertTx(ctx context.Context, tx *sqlx.Tx, it models.Item) (i
 	if err := tx.QueryRowxContext(ctx, q,
 		it.OrderUID, it.ChrtId, it.TrackNumber, it.Price, it.Rid,
 		it.Name, it.Sale, it.Size, it.TotalPrice, it.NmId, it.Brand, it.Status,
+		it.FulfillmentStatus, it.StatusChangedAt,
 	).Scan(&id); err != nil {
 		return 0, errors.WithMessage(err, "upsert item (tx)")
 	}
 	return id, nil
 }
 
+type itemKey struct {
+	chrtID int
+	rid    string
+}
+
+func keyOf(it models.Item) itemKey {
+	return itemKey{chrtID: it.ChrtId, rid: it.Rid}
+}
+
+// uniqueItems applies the items natural key (chrt_id, rid): a repeated key
+// replaces the earlier item in place.
+func uniqueItems(items []models.Item) []models.Item {
+	pos := make(map[itemKey]int, len(items))
+	out := make([]models.Item, 0, len(items))
+	for _, it := range items {
+		if i, ok := pos[keyOf(it)]; ok {
+			out[i] = it
+			continue
+		}
+		pos[keyOf(it)] = len(out)
+		out = append(out, it)
+	}
+	return out
+}
+
+// SyncTx makes the stored items of an order match items, keyed by
+// (order_uid, chrt_id, rid): new items are inserted, changed ones updated in
+// place and missing ones deleted, so unchanged rows keep their ids.
+// Fulfillment statuses are not synced: items keep their stored one and new
+// items start as created at the given time. items are updated to match.
+func (r *ItemRepo) SyncTx(ctx context.Context, tx *sqlx.Tx, orderUID string, items []models.Item, at time.Time) error {
+	const sel = `
+		SELECT order_uid, chrt_id, track_number, price, rid, name, sale, size,
+		       total_price, nm_id, brand, status, fulfillment_status, status_changed_at
+		FROM items
+		WHERE order_uid = $1
+		FOR UPDATE
+	`
+	var stored []models.Item
+	if err := tx.SelectContext(ctx, &stored, sel, orderUID); err != nil {
+		return errors.WithMessage(err, "select items for sync")
+	}
+
+	existing := make(map[itemKey]models.Item, len(stored))
+	for _, it := range stored {
+		existing[keyOf(it)] = it
+	}
+
+	seen := make(map[itemKey]bool, len(items))
+	for i := range items {
+		it := &items[i]
+		it.OrderUID = orderUID
+		k := keyOf(*it)
+		seen[k] = true
+
+		old, ok := existing[k]
+		// items store amounts only, in the currency of the order payment
+		old.Price.Currency, old.TotalPrice.Currency = it.Price.Currency, it.TotalPrice.Currency
+		if ok {
+			it.FulfillmentStatus, it.StatusChangedAt = old.FulfillmentStatus, old.StatusChangedAt
+		} else {
+			it.FulfillmentStatus, it.StatusChangedAt = models.StatusCreated, at
+		}
+		if ok && old == *it {
+			continue
+		}
+		if _, err := r.UpsertTx(ctx, tx, *it); err != nil {
+			return err
+		}
+		existing[k] = *it
+	}
+
+	const del = `DELETE FROM items WHERE order_uid = $1 AND chrt_id = $2 AND rid = $3`
+	for k := range existing {
+		if seen[k] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, del, orderUID, k.chrtID, k.rid); err != nil {
+			return errors.WithMessage(err, "delete stale item")
+		}
+	}
+	return nil
+}
+
 func (r *ItemRepo) GetByOrderUID(ctx context.Context, orderUID string) ([]models.Item, error) {
 	const q = `
 		SELECT order_uid, chrt_id, track_number, price, rid, name, sale, size,
-		       total_price, nm_id, brand, status
+		       total_price, nm_id, brand, status, fulfillment_status, status_changed_at
 		FROM items
 		WHERE order_uid = $1
 		ORDER BY id
@@ -65,6 +149,26 @@ func (r *ItemRepo) GetByOrderUID(ctx context.Context, orderUID string) ([]models
 	return out, nil
 }
 
+func (r *ItemRepo) GetByOrderUIDs(ctx context.Context, q sqlx.QueryerContext, orderUIDs []string) (map[string][]models.Item, error) {
+	const sel = `
+		SELECT order_uid, chrt_id, track_number, price, rid, name, sale, size,
+		       total_price, nm_id, brand, status, fulfillment_status, status_changed_at
+		FROM items
+		WHERE order_uid = ANY($1)
+		ORDER BY order_uid, id
+	`
+	var items []models.Item
+	if err := sqlx.SelectContext(ctx, q, &items, sel, pq.Array(orderUIDs)); err != nil {
+		return nil, errors.WithMessage(err, "select items by order_uids")
+	}
+
+	out := make(map[string][]models.Item, len(orderUIDs))
+	for _, it := range items {
+		out[it.OrderUID] = append(out[it.OrderUID], it)
+	}
+	return out, nil
+}
+
 func (r *ItemRepo) DeleteByOrderUIDTx(ctx context.Context, tx *sqlx.Tx, orderUID string) (int64, error) {
 	const q = `DELETE FROM items WHERE order_uid = $1`
 	res, err := tx.ExecContext(ctx, q, orderUID)
@@ -0,0 +1,715 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wb/internal/models"
+
+	"github.com/pkg/errors"
+)
+
+type memoryOrder struct {
+	order     models.Order
+	deletedAt *time.Time
+}
+
+// MemoryOrderRepo keeps orders and their history in memory with the same
+// semantics as OrderRepo: versions, soft deletes, keyset pagination and
+// lookups. It is meant for demos and tests; nothing is persisted.
+type MemoryOrderRepo struct {
+	mu       sync.RWMutex
+	orders   map[string]*memoryOrder
+	history  map[string][]models.OrderVersion
+	statuses map[string][]models.StatusRecord
+
+	itemStatuses map[string][]models.ItemStatusRecord
+}
+
+func NewMemoryOrderRepo() *MemoryOrderRepo {
+	return &MemoryOrderRepo{
+		orders:   make(map[string]*memoryOrder),
+		history:  make(map[string][]models.OrderVersion),
+		statuses: make(map[string][]models.StatusRecord),
+
+		itemStatuses: make(map[string][]models.ItemStatusRecord),
+	}
+}
+
+// cloneOrder copies o so that callers cannot modify stored items.
+func cloneOrder(o models.Order) models.Order {
+	o.Items = append([]models.Item(nil), o.Items...)
+	return o
+}
+
+func (r *MemoryOrderRepo) Get(_ context.Context, orderUID string) (*models.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.orders[orderUID]
+	if !ok || m.deletedAt != nil {
+		return nil, nil
+	}
+	o := cloneOrder(m.order)
+	return &o, nil
+}
+
+// Upsert stores o and sets it to the stored order, as OrderRepo.Upsert sets
+// the stored version and statuses.
+func (r *MemoryOrderRepo) Upsert(ctx context.Context, o *models.Order, expectedVersion int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.upsert(ctx, *o, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+	*o = cloneOrder(stored)
+	return o.Version, nil
+}
+
+func (r *MemoryOrderRepo) upsert(ctx context.Context, o models.Order, expectedVersion int64) (models.Order, error) {
+	m, exists := r.orders[o.OrderUID]
+	if exists && m.deletedAt != nil {
+		return o, models.ErrOrderDeleted
+	}
+	if !exists && (expectedVersion > 0 || expectedVersion == models.AnyVersion) {
+		return o, &models.ConflictError{OrderUID: o.OrderUID, Expected: expectedVersion}
+	}
+	if exists && expectedVersion > 0 && m.order.Version != expectedVersion {
+		return o, &models.ConflictError{OrderUID: o.OrderUID, Expected: expectedVersion, Actual: m.order.Version}
+	}
+
+	o = cloneOrder(o)
+	o.Delivery.OrderUID = o.OrderUID
+	o.Payment.OrderUID = o.OrderUID
+	o.FillCurrency()
+
+	operation := models.OrderCreated
+	o.Version = 1
+	o.Status = models.StatusCreated
+	var stored []models.Item
+	itemsAt := o.DateCreated
+	if exists {
+		operation = models.OrderUpdated
+		o.Version = m.order.Version + 1
+		o.Status = m.order.Status
+		stored = m.order.Items
+		itemsAt = time.Now()
+	} else {
+		r.recordStatus(ctx, models.StatusRecord{OrderUID: o.OrderUID, Status: o.Status, ChangedAt: o.DateCreated})
+	}
+	o.Items = syncItems(o, stored, itemsAt)
+	if status, ok := models.DeriveStatus(o.Items); ok && status != o.Status {
+		r.recordStatus(ctx, models.StatusRecord{
+			OrderUID: o.OrderUID, From: o.Status, Status: status, Reason: models.ReasonItemsChanged, ChangedAt: itemsAt,
+		})
+		o.Status = status
+	}
+	r.orders[o.OrderUID] = &memoryOrder{order: o}
+
+	if err := r.record(ctx, o.OrderUID, operation, o); err != nil {
+		return o, err
+	}
+	if exists && m.order.CustomerId != o.CustomerId {
+		o.PreviousCustomerId = m.order.CustomerId
+	}
+	return o, nil
+}
+
+// syncItems applies the items natural key, see uniqueItems. Items keep the
+// fulfillment status they have in stored; new ones start as created at the
+// given time.
+func syncItems(o models.Order, stored []models.Item, at time.Time) []models.Item {
+	prev := make(map[itemKey]models.Item, len(stored))
+	for _, it := range stored {
+		prev[keyOf(it)] = it
+	}
+
+	items := uniqueItems(o.Items)
+	for i := range items {
+		it := &items[i]
+		it.OrderUID = o.OrderUID
+		if it.TrackNumber == "" {
+			it.TrackNumber = o.TrackNumber
+		}
+		it.FulfillmentStatus, it.StatusChangedAt = models.StatusCreated, at
+		if old, ok := prev[keyOf(*it)]; ok {
+			it.FulfillmentStatus, it.StatusChangedAt = old.FulfillmentStatus, old.StatusChangedAt
+		}
+	}
+	return items
+}
+
+func (r *MemoryOrderRepo) record(ctx context.Context, orderUID, operation string, snapshot any) error {
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.WithMessage(err, "marshal order snapshot")
+	}
+	a := models.AuditFrom(ctx)
+	r.history[orderUID] = append(r.history[orderUID], models.OrderVersion{
+		OrderUID:   orderUID,
+		Version:    len(r.history[orderUID]) + 1,
+		Operation:  operation,
+		Snapshot:   b,
+		Source:     a.Source,
+		Actor:      a.Actor,
+		RecordedAt: time.Now(),
+	})
+	return nil
+}
+
+func (r *MemoryOrderRepo) recordStatus(ctx context.Context, rec models.StatusRecord) {
+	a := models.AuditFrom(ctx)
+	rec.Source, rec.Actor, rec.RecordedAt = a.Source, a.Actor, time.Now()
+	r.statuses[rec.OrderUID] = append(r.statuses[rec.OrderUID], rec)
+}
+
+func (r *MemoryOrderRepo) BulkUpsert(ctx context.Context, orders []models.Order) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := make(map[string]string)
+	merged := lastByOrderUID(orders)
+	n := 0
+	for _, o := range merged {
+		stored, err := r.upsert(ctx, o, 0)
+		if errors.Is(err, models.ErrOrderDeleted) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		n++
+		if stored.PreviousCustomerId != "" {
+			previous[o.OrderUID] = stored.PreviousCustomerId
+		}
+	}
+	setPreviousCustomers(orders, previous)
+	return n, nil
+}
+
+func (r *MemoryOrderRepo) Delete(ctx context.Context, orderUID string) (string, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.orders[orderUID]
+	if !ok || m.deletedAt != nil {
+		return "", false, nil
+	}
+	now := time.Now()
+	m.deletedAt = &now
+	m.order.Version++
+
+	if err := r.record(ctx, orderUID, models.OrderDeleted, map[string]string{"order_uid": orderUID}); err != nil {
+		return "", false, err
+	}
+	return m.order.CustomerId, true, nil
+}
+
+func (r *MemoryOrderRepo) Restore(ctx context.Context, orderUID string) (*models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.orders[orderUID]
+	if !ok || m.deletedAt == nil {
+		return nil, nil
+	}
+	m.deletedAt = nil
+	m.order.Version++
+
+	if err := r.record(ctx, orderUID, models.OrderRestored, m.order); err != nil {
+		return nil, err
+	}
+	o := cloneOrder(m.order)
+	return &o, nil
+}
+
+func (r *MemoryOrderRepo) Purge(_ context.Context, olderThan time.Duration) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	cutoff := time.Now().Add(-olderThan)
+	for uid, m := range r.orders {
+		if m.deletedAt != nil && m.deletedAt.Before(cutoff) {
+			delete(r.orders, uid)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// live returns copies of the live orders matching keep.
+func (r *MemoryOrderRepo) live(keep func(o *models.Order) bool) []models.Order {
+	var out []models.Order
+	for _, m := range r.orders {
+		if m.deletedAt == nil && keep(&m.order) {
+			out = append(out, cloneOrder(m.order))
+		}
+	}
+	return out
+}
+
+// newestFirst sorts by date_created, then order_uid, descending.
+func newestFirst(orders []models.Order) {
+	sort.Slice(orders, func(i, j int) bool {
+		a, b := orders[i], orders[j]
+		if !a.DateCreated.Equal(b.DateCreated) {
+			return a.DateCreated.After(b.DateCreated)
+		}
+		return a.OrderUID > b.OrderUID
+	})
+}
+
+func (r *MemoryOrderRepo) GetLastOrders(_ context.Context, n int) ([]models.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	orders := r.live(func(*models.Order) bool { return true })
+	newestFirst(orders)
+	if len(orders) > n {
+		orders = orders[:n]
+	}
+	return orders, nil
+}
+
+func (r *MemoryOrderRepo) List(_ context.Context, f models.OrderFilter) (*models.OrderPage, error) {
+	if f.Limit <= 0 {
+		f.Limit = defaultPageSize
+	}
+	if f.Limit > maxPageSize {
+		f.Limit = maxPageSize
+	}
+
+	var cursor *listCursor
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		cursor = &c
+	}
+
+	byAmount := f.SortBy == models.SortByAmount
+	// compare orders a and b by (sort column, order_uid).
+	compare := func(aDate time.Time, aAmount int64, aUID string, bDate time.Time, bAmount int64, bUID string) int {
+		switch {
+		case byAmount && aAmount != bAmount:
+			if aAmount < bAmount {
+				return -1
+			}
+			return 1
+		case !byAmount && !aDate.Equal(bDate):
+			if aDate.Before(bDate) {
+				return -1
+			}
+			return 1
+		case aUID < bUID:
+			return -1
+		case aUID > bUID:
+			return 1
+		}
+		return 0
+	}
+	dir := -1
+	if f.Asc {
+		dir = 1
+	}
+
+	eq := func(filter, value string) bool { return filter == "" || filter == value }
+
+	r.mu.RLock()
+	orders := r.live(func(o *models.Order) bool {
+		p := o.Payment
+		switch {
+		case !eq(f.CustomerID, o.CustomerId), !eq(f.TrackNumber, o.TrackNumber),
+			!eq(f.DeliveryService, o.DeliveryService), !eq(f.Locale, o.Locale),
+			!eq(f.Currency, p.Currency), !eq(f.Provider, p.Provider), !eq(f.Bank, p.Bank):
+			return false
+		case !f.CreatedFrom.IsZero() && o.DateCreated.Before(f.CreatedFrom),
+			!f.CreatedTo.IsZero() && !o.DateCreated.Before(f.CreatedTo),
+			f.AmountMin != nil && p.Amount.Amount < *f.AmountMin,
+			f.AmountMax != nil && p.Amount.Amount > *f.AmountMax,
+			f.ItemStatus != "" && !hasItemIn(o, f.ItemStatus):
+			return false
+		case cursor != nil:
+			return compare(o.DateCreated, p.Amount.Amount, o.OrderUID, cursor.Date, cursor.Amount, cursor.OrderUID)*dir > 0
+		}
+		return true
+	})
+	r.mu.RUnlock()
+
+	sort.Slice(orders, func(i, j int) bool {
+		a, b := orders[i], orders[j]
+		return compare(a.DateCreated, a.Payment.Amount.Amount, a.OrderUID, b.DateCreated, b.Payment.Amount.Amount, b.OrderUID)*dir < 0
+	})
+
+	page := &models.OrderPage{Orders: orders}
+	if len(orders) > f.Limit {
+		page.Orders = orders[:f.Limit]
+		last := page.Orders[f.Limit-1]
+		page.NextCursor = encodeCursor(listCursor{
+			Date:     last.DateCreated,
+			Amount:   last.Payment.Amount.Amount,
+			OrderUID: last.OrderUID,
+		})
+	}
+	return page, nil
+}
+
+var memoryLookups = map[models.LookupKey]func(o *models.Order, value string) bool{
+	models.LookupTrackNumber: func(o *models.Order, v string) bool { return o.TrackNumber == v },
+	models.LookupTransaction: func(o *models.Order, v string) bool { return o.Payment.Transaction == v },
+	models.LookupChrtID: func(o *models.Order, v string) bool {
+		return hasItem(o, func(it models.Item) bool { return strconv.Itoa(it.ChrtId) == v })
+	},
+	models.LookupRid: func(o *models.Order, v string) bool {
+		return hasItem(o, func(it models.Item) bool { return it.Rid == v })
+	},
+	models.LookupNmID: func(o *models.Order, v string) bool {
+		return hasItem(o, func(it models.Item) bool { return strconv.Itoa(it.NmId) == v })
+	},
+}
+
+func hasItem(o *models.Order, match func(models.Item) bool) bool {
+	for _, it := range o.Items {
+		if match(it) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasItemIn(o *models.Order, status models.OrderStatus) bool {
+	for _, it := range o.Items {
+		if it.FulfillmentStatus == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryOrderRepo) Lookup(_ context.Context, key models.LookupKey, value string) ([]models.Order, error) {
+	match, ok := memoryLookups[key]
+	if !ok {
+		return nil, errors.Errorf("unknown lookup key %q", key)
+	}
+
+	r.mu.RLock()
+	orders := r.live(func(o *models.Order) bool { return match(o, value) })
+	r.mu.RUnlock()
+
+	newestFirst(orders)
+	if len(orders) > maxPageSize {
+		orders = orders[:maxPageSize]
+	}
+	return orders, nil
+}
+
+func (r *MemoryOrderRepo) CustomerHistory(_ context.Context, customerID string) (*models.CustomerHistory, error) {
+	r.mu.RLock()
+	orders := r.live(func(o *models.Order) bool { return o.CustomerId == customerID })
+	r.mu.RUnlock()
+
+	if len(orders) == 0 {
+		return nil, nil
+	}
+
+	h := &models.CustomerHistory{CustomerID: customerID}
+	spent := make(map[string]models.Money)
+	reporting := make(map[string]models.Money)
+	for _, o := range orders {
+		h.OrderCount++
+		total := o.Payment.Amount
+		if prev, ok := spent[total.Currency]; ok {
+			var err error
+			if total, err = prev.Add(total); err != nil {
+				return nil, errors.WithMessagef(err, "customer %s total", customerID)
+			}
+		}
+		if total.Currency != "" { // an order without a payment
+			spent[total.Currency] = total
+		}
+		if m, ok := o.Payment.Reporting(); ok {
+			if prev, ok := reporting[m.Currency]; ok {
+				var err error
+				if m, err = prev.Add(m); err != nil {
+					return nil, errors.WithMessagef(err, "customer %s reporting total", customerID)
+				}
+			}
+			reporting[m.Currency] = m
+		}
+		if h.FirstOrderAt.IsZero() || o.DateCreated.Before(h.FirstOrderAt) {
+			h.FirstOrderAt = o.DateCreated
+		}
+		if o.DateCreated.After(h.LastOrderAt) {
+			h.LastOrderAt = o.DateCreated
+		}
+	}
+
+	for _, m := range spent {
+		h.TotalSpent = append(h.TotalSpent, m)
+	}
+	sort.Slice(h.TotalSpent, func(i, j int) bool { return h.TotalSpent[i].Currency < h.TotalSpent[j].Currency })
+	for _, m := range reporting {
+		h.TotalReporting = append(h.TotalReporting, m)
+	}
+	sort.Slice(h.TotalReporting, func(i, j int) bool { return h.TotalReporting[i].Currency < h.TotalReporting[j].Currency })
+
+	newestFirst(orders)
+	if len(orders) > maxPageSize {
+		orders = orders[:maxPageSize]
+	}
+	h.Orders = orders
+	return h, nil
+}
+
+func (r *MemoryOrderRepo) History(_ context.Context, orderUID string) ([]models.OrderVersion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]models.OrderVersion(nil), r.history[orderUID]...), nil
+}
+
+func (r *MemoryOrderRepo) GetAsOf(_ context.Context, orderUID string, t time.Time) (*models.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := r.history[orderUID]
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].RecordedAt.After(t) })
+	if i == 0 || versions[i-1].Operation == models.OrderDeleted {
+		return nil, nil
+	}
+
+	var o models.Order
+	if err := json.Unmarshal(versions[i-1].Snapshot, &o); err != nil {
+		return nil, errors.WithMessage(err, "decode order snapshot")
+	}
+	return &o, nil
+}
+
+// Search mirrors OrderRepo.Search with plain word-prefix matching; the rank
+// is the number of matching delivery and item texts.
+func (r *MemoryOrderRepo) Search(_ context.Context, q string, limit int) ([]models.SearchHit, error) {
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	r.mu.RLock()
+	orders := r.live(func(*models.Order) bool { return true })
+	r.mu.RUnlock()
+
+	var hits []models.SearchHit
+	for _, o := range orders {
+		d := o.Delivery
+		delivery := strings.Join([]string{d.Name, d.Email, d.City, d.Address}, ", ")
+		doc := []string{delivery, d.Region}
+		for _, it := range o.Items {
+			doc = append(doc, it.Name, it.Brand)
+		}
+		if !matchesAll(strings.Join(doc, " "), terms) {
+			continue
+		}
+
+		h := models.SearchHit{Order: o}
+		if matchesAny(delivery+" "+d.Region, terms) {
+			h.Rank++
+			h.Highlights = append(h.Highlights, highlight(delivery, terms))
+		}
+		var items []string
+		for _, it := range o.Items {
+			text := it.Name + " " + it.Brand
+			if matchesAny(text, terms) {
+				h.Rank++
+				items = append(items, highlight(text, terms))
+			}
+		}
+		if len(items) > 0 {
+			h.Highlights = append(h.Highlights, strings.Join(items, "; "))
+		}
+		hits = append(hits, h)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		if !a.Order.DateCreated.Equal(b.Order.DateCreated) {
+			return a.Order.DateCreated.After(b.Order.DateCreated)
+		}
+		return a.Order.OrderUID < b.Order.OrderUID
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+func matchesAll(text string, terms []string) bool {
+	words := searchTerm.FindAllString(strings.ToLower(text), -1)
+	for _, t := range terms {
+		found := false
+		for _, w := range words {
+			if strings.HasPrefix(w, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(text string, terms []string) bool {
+	for _, t := range terms {
+		if matchesAll(text, []string{t}) {
+			return true
+		}
+	}
+	return false
+}
+
+func highlight(text string, terms []string) string {
+	return searchTerm.ReplaceAllStringFunc(text, func(w string) string {
+		lw := strings.ToLower(w)
+		for _, t := range terms {
+			if strings.HasPrefix(lw, t) {
+				return "<mark>" + w + "</mark>"
+			}
+		}
+		return w
+	})
+}
+
+func (r *MemoryOrderRepo) UnconvertedPayments(_ context.Context, after string, limit int) ([]models.Payment, error) {
+	return r.payments(after, limit, func(p models.Payment) bool { return p.ReportingCurrency == "" }), nil
+}
+
+func (r *MemoryOrderRepo) PaymentsSince(_ context.Context, currency string, since time.Time, after string, limit int) ([]models.Payment, error) {
+	return r.payments(after, limit, func(p models.Payment) bool {
+		return (currency == "" || p.Currency == currency) && p.PaymentDt >= since.Unix()
+	}), nil
+}
+
+// payments returns up to limit payments matching keep with order_uid after
+// after, ordered by order_uid.
+func (r *MemoryOrderRepo) payments(after string, limit int, keep func(models.Payment) bool) []models.Payment {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var out []models.Payment
+	for uid, m := range r.orders {
+		if uid > after && keep(m.order.Payment) {
+			p := m.order.Payment
+			p.OrderUID = uid
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].OrderUID < out[j].OrderUID })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func (r *MemoryOrderRepo) SetReporting(_ context.Context, payments []models.Payment) (map[string]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	customers := make(map[string]string, len(payments))
+	for _, p := range payments {
+		m, ok := r.orders[p.OrderUID]
+		if !ok {
+			continue
+		}
+		// Like OrderRepo.SetReporting, skip payments changed since they were read.
+		if stored := m.order.Payment; stored.Amount.Amount != p.Amount.Amount ||
+			stored.Currency != p.Currency || stored.PaymentDt != p.PaymentDt {
+			continue
+		}
+		m.order.Payment.ReportingAmount = p.ReportingAmount
+		m.order.Payment.ReportingCurrency = p.ReportingCurrency
+		customers[p.OrderUID] = m.order.CustomerId
+	}
+	return customers, nil
+}
+
+func (r *MemoryOrderRepo) Fulfillment(_ context.Context, orderUID string) (*models.Fulfillment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.orders[orderUID]
+	if !ok || m.deletedAt != nil {
+		return nil, nil
+	}
+	return &models.Fulfillment{
+		OrderUID: orderUID,
+		Status:   m.order.Status,
+		Version:  m.order.Version,
+		Items:    append([]models.Item(nil), m.order.Items...),
+	}, nil
+}
+
+func (r *MemoryOrderRepo) SetStatus(ctx context.Context, u models.StatusUpdate) (*models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	orderUID := u.Order.OrderUID
+	m, ok := r.orders[orderUID]
+	if !ok || m.deletedAt != nil || m.order.Version != u.Version {
+		return nil, nil
+	}
+
+	o := cloneOrder(m.order)
+	o.Status = u.Order.Status
+	o.Version++
+	a := models.AuditFrom(ctx)
+	for _, rec := range u.Items {
+		for i := range o.Items {
+			if o.Items[i].Ref() == rec.ItemRef {
+				o.Items[i].FulfillmentStatus, o.Items[i].StatusChangedAt = rec.Status, rec.ChangedAt
+			}
+		}
+		rec.OrderUID, rec.Source, rec.Actor, rec.RecordedAt = orderUID, a.Source, a.Actor, time.Now()
+		r.itemStatuses[orderUID] = append(r.itemStatuses[orderUID], rec)
+	}
+	if u.Order.From != u.Order.Status {
+		r.recordStatus(ctx, u.Order)
+	}
+	m.order = o
+
+	if err := r.record(ctx, orderUID, models.OrderStatusChanged, o); err != nil {
+		return nil, err
+	}
+	o = cloneOrder(o)
+	return &o, nil
+}
+
+func (r *MemoryOrderRepo) StatusHistory(_ context.Context, orderUID string) ([]models.StatusRecord, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]models.StatusRecord(nil), r.statuses[orderUID]...), nil
+}
+
+func (r *MemoryOrderRepo) ItemStatusHistory(_ context.Context, orderUID string) ([]models.ItemStatusRecord, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]models.ItemStatusRecord(nil), r.itemStatuses[orderUID]...), nil
+}
@@ -0,0 +1,417 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"wb/internal/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var stagingTables = []string{
+	`CREATE TEMP TABLE stage_orders (LIKE orders INCLUDING DEFAULTS, payload JSONB NOT NULL) ON COMMIT DROP`,
+	`CREATE TEMP TABLE stage_deliveries (LIKE deliveries INCLUDING DEFAULTS) ON COMMIT DROP`,
+	`CREATE TEMP TABLE stage_payments (LIKE payments INCLUDING DEFAULTS) ON COMMIT DROP`,
+	`CREATE TEMP TABLE stage_items (LIKE items INCLUDING DEFAULTS) ON COMMIT DROP`,
+}
+
+const lockStagedOrders = `
+	SELECT pg_advisory_xact_lock(` + orderLockClass + `, hashtext(order_uid))
+	FROM stage_orders
+	ORDER BY order_uid
+`
+
+// dropDeletedOrders removes soft-deleted orders from the staging tables so
+// that a redelivered message does not bring them back.
+const dropDeletedOrders = `
+	WITH deleted AS (
+		DELETE FROM stage_orders s
+		USING orders o
+		WHERE o.order_uid = s.order_uid AND o.deleted_at IS NOT NULL
+		RETURNING s.order_uid
+	),
+	deliveries AS (
+		DELETE FROM stage_deliveries WHERE order_uid IN (SELECT order_uid FROM deleted)
+	),
+	payments AS (
+		DELETE FROM stage_payments WHERE order_uid IN (SELECT order_uid FROM deleted)
+	),
+	items AS (
+		DELETE FROM stage_items WHERE order_uid IN (SELECT order_uid FROM deleted)
+	)
+	SELECT COUNT(*) FROM deleted
+`
+
+// selectMovedOrders lists staged orders whose stored customer differs, so
+// the previous customer can be reported to the caller.
+const selectMovedOrders = `
+	SELECT o.order_uid, o.customer_id
+	FROM orders o
+	JOIN stage_orders s ON s.order_uid = o.order_uid
+	WHERE o.customer_id <> s.customer_id
+`
+
+// mergeStatements run in order after the staged orders are locked; those with audit set take the actor and
+// source from models.AuditFrom as $1 and $2.
+var mergeStatements = []struct {
+	name  string
+	audit bool
+	q     string
+}{
+	{"move order partitions", false, `
+		UPDATE orders o SET date_created = s.date_created
+		FROM stage_orders s
+		WHERE o.order_uid = s.order_uid AND o.date_created <> s.date_created
+	`},
+	{"merge orders", true, `
+		WITH upserted AS (
+			INSERT INTO orders (
+				order_uid, track_number, entry, locale, internal_signature, customer_id,
+				delivery_service, shardkey, sm_id, date_created, oof_shard
+			)
+			SELECT order_uid, track_number, entry, locale, internal_signature, customer_id,
+			       delivery_service, shardkey, sm_id, date_created, oof_shard
+			FROM stage_orders
+			ON CONFLICT (order_uid, date_created) DO UPDATE SET
+				track_number=EXCLUDED.track_number,
+				entry=EXCLUDED.entry,
+				locale=EXCLUDED.locale,
+				internal_signature=EXCLUDED.internal_signature,
+				customer_id=EXCLUDED.customer_id,
+				delivery_service=EXCLUDED.delivery_service,
+				shardkey=EXCLUDED.shardkey,
+				sm_id=EXCLUDED.sm_id,
+				oof_shard=EXCLUDED.oof_shard,
+				version=orders.version + 1
+			RETURNING order_uid, (xmax = 0) AS inserted
+		),
+		changes AS (
+			SELECT u.order_uid, s.payload,
+			       CASE WHEN u.inserted THEN '` + models.OrderCreated + `' ELSE '` + models.OrderUpdated + `' END AS operation
+			FROM upserted u
+			JOIN stage_orders s ON s.order_uid = u.order_uid
+		),
+		events AS (
+			INSERT INTO order_events (order_uid, event_type, payload)
+			SELECT order_uid, operation, payload FROM changes
+		),
+		statuses AS (
+			INSERT INTO order_status_history (order_uid, status, changed_at, actor, source)
+			SELECT u.order_uid, '` + string(models.StatusCreated) + `', s.date_created, $1, $2
+			FROM upserted u
+			JOIN stage_orders s ON s.order_uid = u.order_uid
+			WHERE u.inserted
+		)
+		INSERT INTO order_history (order_uid, version, operation, snapshot, actor, source)
+		SELECT c.order_uid,
+		       COALESCE((SELECT MAX(h.version) FROM order_history h WHERE h.order_uid = c.order_uid), 0) + 1,
+		       c.operation, c.payload, $1, $2
+		FROM changes c
+	`},
+	{"merge deliveries", false, `
+		INSERT INTO deliveries (order_uid, name, phone, zip, city, address, region, email)
+		SELECT order_uid, name, phone, zip, city, address, region, email
+		FROM stage_deliveries
+		ON CONFLICT (order_uid) DO UPDATE SET
+			name=EXCLUDED.name,
+			phone=EXCLUDED.phone,
+			zip=EXCLUDED.zip,
+			city=EXCLUDED.city,
+			address=EXCLUDED.address,
+			region=EXCLUDED.region,
+			email=EXCLUDED.email
+	`},
+	{"merge payments", false, `
+		INSERT INTO payments (
+			order_uid, transaction, request_id, currency, provider, amount,
+			payment_dt, bank, delivery_cost, goods_total, custom_fee,
+			reporting_amount, reporting_currency
+		)
+		SELECT order_uid, transaction, request_id, currency, provider, amount,
+		       payment_dt, bank, delivery_cost, goods_total, custom_fee,
+		       reporting_amount, reporting_currency
+		FROM stage_payments
+		ON CONFLICT (order_uid) DO UPDATE SET
+			transaction   = EXCLUDED.transaction,
+			request_id    = EXCLUDED.request_id,
+			currency      = EXCLUDED.currency,
+			provider      = EXCLUDED.provider,
+			amount        = EXCLUDED.amount,
+			payment_dt    = EXCLUDED.payment_dt,
+			bank          = EXCLUDED.bank,
+			delivery_cost = EXCLUDED.delivery_cost,
+			goods_total   = EXCLUDED.goods_total,
+			custom_fee    = EXCLUDED.custom_fee,
+			reporting_amount   = EXCLUDED.reporting_amount,
+			reporting_currency = EXCLUDED.reporting_currency
+	`},
+	{"delete stale items", false, `
+		DELETE FROM items i
+		USING stage_orders o
+		WHERE i.order_uid = o.order_uid
+		  AND NOT EXISTS (
+			SELECT 1 FROM stage_items s
+			WHERE s.order_uid = i.order_uid AND s.chrt_id = i.chrt_id AND s.rid = i.rid
+		  )
+	`},
+	{"merge items", false, `
+		INSERT INTO items (
+			order_uid, chrt_id, track_number, price, rid, name, sale, size,
+			total_price, nm_id, brand, status, fulfillment_status, status_changed_at
+		)
+		SELECT i.order_uid, i.chrt_id, i.track_number, i.price, i.rid, i.name, i.sale, i.size,
+		       i.total_price, i.nm_id, i.brand, i.status, '` + string(models.StatusCreated) + `', o.date_created
+		FROM stage_items i
+		JOIN stage_orders o ON o.order_uid = i.order_uid
+		ORDER BY i.id
+		ON CONFLICT (order_uid, chrt_id, rid) DO UPDATE SET
+			track_number=EXCLUDED.track_number,
+			price=EXCLUDED.price,
+			name=EXCLUDED.name,
+			sale=EXCLUDED.sale,
+			size=EXCLUDED.size,
+			total_price=EXCLUDED.total_price,
+			nm_id=EXCLUDED.nm_id,
+			brand=EXCLUDED.brand,
+			status=EXCLUDED.status
+		WHERE (items.track_number, items.price, items.name, items.sale, items.size,
+		       items.total_price, items.nm_id, items.brand, items.status)
+		      IS DISTINCT FROM
+		      (EXCLUDED.track_number, EXCLUDED.price, EXCLUDED.name, EXCLUDED.sale, EXCLUDED.size,
+		       EXCLUDED.total_price, EXCLUDED.nm_id, EXCLUDED.brand, EXCLUDED.status)
+	`},
+	{"derive order statuses", true, `
+		WITH derived AS (
+			SELECT o.order_uid, o.status AS from_status,
+			       COALESCE(
+			           (array_agg(i.fulfillment_status ORDER BY array_position(` + statusProgress + `, i.fulfillment_status))
+			               FILTER (WHERE i.fulfillment_status <> '` + string(models.StatusCancelled) + `'))[1],
+			           '` + string(models.StatusCancelled) + `'
+			       ) AS status
+			FROM orders o
+			JOIN items i ON i.order_uid = o.order_uid
+			WHERE o.order_uid IN (SELECT order_uid FROM stage_orders)
+			GROUP BY o.order_uid, o.status
+		),
+		changed AS (
+			UPDATE orders o SET status = d.status
+			FROM derived d
+			WHERE o.order_uid = d.order_uid AND d.status <> d.from_status
+			RETURNING o.order_uid, d.from_status, d.status
+		)
+		INSERT INTO order_status_history (order_uid, from_status, status, reason, changed_at, actor, source)
+		SELECT order_uid, from_status, status, '` + models.ReasonItemsChanged + `', now(), $1, $2
+		FROM changed
+	`},
+}
+
+// statusProgress lists the statuses of items that are not cancelled in the
+// order models.DeriveStatus ranks them, least advanced first.
+const statusProgress = `ARRAY['` + string(models.StatusCreated) + `', '` + string(models.StatusPaid) + `', '` +
+	string(models.StatusAssembling) + `', '` + string(models.StatusShipped) + `', '` +
+	string(models.StatusDelivered) + `', '` + string(models.StatusReturned) + `']`
+
+// BulkUpsert writes many orders in one transaction: rows are COPY'd into
+// temporary staging tables and merged with set-based statements. When the
+// same order_uid occurs more than once the last occurrence wins. Soft-deleted
+// orders are skipped and not counted in n. Orders that moved to another
+// customer get PreviousCustomerId set.
+func (r *OrderRepo) BulkUpsert(ctx context.Context, orders []models.Order) (n int, err error) {
+	ctx, span := tracer.Start(ctx, "OrderRepo.BulkUpsert",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("orders", len(orders))),
+	)
+	defer func() { endSpan(span, err) }()
+
+	in := orders
+	orders = lastByOrderUID(orders)
+	if len(orders) == 0 {
+		return 0, nil
+	}
+
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return 0, errors.WithMessage(err, "begin tx")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = noTimeoutTx(ctx, tx); err != nil {
+		return 0, err
+	}
+	for _, q := range stagingTables {
+		if _, err = tx.ExecContext(ctx, q); err != nil {
+			return 0, errors.WithMessage(err, "create staging table")
+		}
+	}
+
+	if err = copyOrders(ctx, tx, orders); err != nil {
+		return 0, err
+	}
+
+	if _, err = tx.ExecContext(ctx, lockStagedOrders); err != nil {
+		return 0, errors.WithMessage(err, "lock orders")
+	}
+	var skipped int
+	if err = tx.GetContext(ctx, &skipped, dropDeletedOrders); err != nil {
+		return 0, errors.WithMessage(err, "drop deleted orders")
+	}
+	var moved []struct {
+		OrderUID   string `db:"order_uid"`
+		CustomerId string `db:"customer_id"`
+	}
+	if err = tx.SelectContext(ctx, &moved, selectMovedOrders); err != nil {
+		return 0, errors.WithMessage(err, "select moved orders")
+	}
+
+	audit := models.AuditFrom(ctx)
+	for _, m := range mergeStatements {
+		var args []any
+		if m.audit {
+			args = []any{audit.Actor, audit.Source}
+		}
+		if _, err = tx.ExecContext(ctx, m.q, args...); err != nil {
+			return 0, errors.WithMessage(err, m.name)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, errors.WithMessage(err, "commit")
+	}
+	previous := make(map[string]string, len(moved))
+	keys := make([]string, 0, 2*len(orders)+len(moved))
+	for _, m := range moved {
+		previous[m.OrderUID] = m.CustomerId
+		keys = append(keys, customerKey(m.CustomerId))
+	}
+	setPreviousCustomers(in, previous)
+	for _, o := range orders {
+		keys = append(keys, o.OrderUID, customerKey(o.CustomerId))
+	}
+	r.recent.mark(keys...)
+	return len(orders) - skipped, nil
+}
+
+// setPreviousCustomers sets PreviousCustomerId of orders from previous,
+// keyed by order_uid.
+func setPreviousCustomers(orders []models.Order, previous map[string]string) {
+	for i := range orders {
+		orders[i].PreviousCustomerId = previous[orders[i].OrderUID]
+	}
+}
+
+func copyOrders(ctx context.Context, tx *sqlx.Tx, orders []models.Order) error {
+	orderRows := make([][]any, 0, len(orders))
+	deliveryRows := make([][]any, 0, len(orders))
+	paymentRows := make([][]any, 0, len(orders))
+	var itemRows [][]any
+
+	for _, o := range orders {
+		o.Items = uniqueItems(o.Items)
+		payload, err := json.Marshal(o)
+		if err != nil {
+			return errors.WithMessage(err, "marshal event payload")
+		}
+		orderRows = append(orderRows, []any{
+			o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerId,
+			o.DeliveryService, o.ShardKey, o.SmId, o.DateCreated, o.OofShard, string(payload),
+		})
+
+		d := o.Delivery
+		deliveryRows = append(deliveryRows, []any{
+			o.OrderUID, d.Name, d.Phone, d.Zip, d.City, d.Address, d.Region, d.Email,
+		})
+
+		p := o.Payment
+		reportingAmount, reportingCurrency := reportingValues(p)
+		paymentRows = append(paymentRows, []any{
+			o.OrderUID, p.Transaction, p.RequestId, p.Currency, p.Provider, p.Amount,
+			p.PaymentDt, p.Bank, p.DeliveryCost, p.GoodsTotal, p.CustomFee,
+			reportingAmount, reportingCurrency,
+		})
+
+		for _, it := range o.Items {
+			if it.TrackNumber == "" {
+				it.TrackNumber = o.TrackNumber
+			}
+			// Only archives carry fulfillment statuses; merged orders ignore them.
+			status, changedAt := it.FulfillmentStatus, it.StatusChangedAt
+			if status == "" {
+				status, changedAt = models.StatusCreated, o.DateCreated
+			}
+			itemRows = append(itemRows, []any{
+				len(itemRows), o.OrderUID, it.ChrtId, it.TrackNumber, it.Price, it.Rid,
+				it.Name, it.Sale, it.Size, it.TotalPrice, it.NmId, it.Brand, it.Status,
+				string(status), changedAt,
+			})
+		}
+	}
+
+	copies := []struct {
+		table string
+		cols  []string
+		rows  [][]any
+	}{
+		{"stage_orders", []string{
+			"order_uid", "track_number", "entry", "locale", "internal_signature", "customer_id",
+			"delivery_service", "shardkey", "sm_id", "date_created", "oof_shard", "payload",
+		}, orderRows},
+		{"stage_deliveries", []string{
+			"order_uid", "name", "phone", "zip", "city", "address", "region", "email",
+		}, deliveryRows},
+		{"stage_payments", []string{
+			"order_uid", "transaction", "request_id", "currency", "provider", "amount",
+			"payment_dt", "bank", "delivery_cost", "goods_total", "custom_fee",
+			"reporting_amount", "reporting_currency",
+		}, paymentRows},
+		{"stage_items", []string{
+			"id", "order_uid", "chrt_id", "track_number", "price", "rid", "name", "sale", "size",
+			"total_price", "nm_id", "brand", "status", "fulfillment_status", "status_changed_at",
+		}, itemRows},
+	}
+
+	for _, c := range copies {
+		if err := copyIn(ctx, tx, c.table, c.cols, c.rows); err != nil {
+			return errors.WithMessagef(err, "copy into %s", c.table)
+		}
+	}
+	return nil
+}
+
+func copyIn(ctx context.Context, tx *sqlx.Tx, table string, cols []string, rows [][]any) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, cols...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
+
+func lastByOrderUID(orders []models.Order) []models.Order {
+	pos := make(map[string]int, len(orders))
+	out := make([]models.Order, 0, len(orders))
+	for _, o := range orders {
+		if i, ok := pos[o.OrderUID]; ok {
+			out[i] = o
+			continue
+		}
+		pos[o.OrderUID] = len(out)
+		out = append(out, o)
+	}
+	return out
+}
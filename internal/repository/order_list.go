@@ -0,0 +1,283 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"wb/internal/models"
+	"wb/pkg/postgres"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type listCursor struct {
+	Date     time.Time `json:"d,omitempty"`
+	Amount   int64     `json:"a,omitempty"`
+	OrderUID string    `json:"id"`
+}
+
+// List returns one page of orders matching f using keyset pagination on
+// (sort column, order_uid).
+func (r *OrderRepo) List(ctx context.Context, f models.OrderFilter) (_ *models.OrderPage, err error) {
+	ctx, span := tracer.Start(ctx, "OrderRepo.List", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { endSpan(span, err) }()
+
+	if f.Limit <= 0 {
+		f.Limit = defaultPageSize
+	}
+	if f.Limit > maxPageSize {
+		f.Limit = maxPageSize
+	}
+	span.SetAttributes(attribute.Int("limit", f.Limit), attribute.String("sort", f.SortBy))
+
+	var where []string
+	var args []any
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	eq := []struct {
+		col string
+		val string
+	}{
+		{"o.customer_id", f.CustomerID},
+		{"o.track_number", f.TrackNumber},
+		{"o.delivery_service", f.DeliveryService},
+		{"o.locale", f.Locale},
+		{"p.currency", f.Currency},
+		{"p.provider", f.Provider},
+		{"p.bank", f.Bank},
+	}
+	for _, c := range eq {
+		if c.val != "" {
+			add(c.col+" = $%d", c.val)
+		}
+	}
+	if !f.CreatedFrom.IsZero() {
+		add("o.date_created >= $%d", f.CreatedFrom)
+	}
+	if !f.CreatedTo.IsZero() {
+		add("o.date_created < $%d", f.CreatedTo)
+	}
+	if f.AmountMin != nil {
+		add("p.amount >= $%d", *f.AmountMin)
+	}
+	if f.AmountMax != nil {
+		add("p.amount <= $%d", *f.AmountMax)
+	}
+	if f.ItemStatus != "" {
+		add("EXISTS (SELECT 1 FROM items i WHERE i.order_uid = o.order_uid AND i.fulfillment_status = $%d)", f.ItemStatus)
+	}
+
+	sortCol := "o.date_created"
+	if f.SortBy == models.SortByAmount {
+		sortCol = "COALESCE(p.amount, 0)"
+	}
+	dir, cmp := "DESC", "<"
+	if f.Asc {
+		dir, cmp = "ASC", ">"
+	}
+
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		var v any = c.Date
+		if f.SortBy == models.SortByAmount {
+			v = c.Amount
+		}
+		args = append(args, v, c.OrderUID)
+		where = append(where, fmt.Sprintf("(%s, o.order_uid) %s ($%d, $%d)", sortCol, cmp, len(args)-1, len(args)))
+	}
+
+	tail := ""
+	if len(where) > 0 {
+		tail = "AND " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit+1)
+	tail += fmt.Sprintf(" ORDER BY %s %s, o.order_uid %s LIMIT $%d", sortCol, dir, dir, len(args))
+
+	var orders []models.Order
+	err = r.read(ctx, "", func(q sqlx.QueryerContext) (err error) {
+		orders, err = r.selectOrders(ctx, q, tail, args...)
+		return err
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "list orders")
+	}
+
+	page := &models.OrderPage{Orders: orders}
+	if len(orders) > f.Limit {
+		page.Orders = orders[:f.Limit]
+		last := page.Orders[f.Limit-1]
+		page.NextCursor = encodeCursor(listCursor{
+			Date:     last.DateCreated,
+			Amount:   last.Payment.Amount.Amount,
+			OrderUID: last.OrderUID,
+		})
+	}
+	return page, nil
+}
+
+func encodeCursor(c listCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, models.ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil || c.OrderUID == "" {
+		return c, models.ErrInvalidCursor
+	}
+	return c, nil
+}
+
+var lookupConditions = map[models.LookupKey]string{
+	models.LookupTrackNumber: `AND o.track_number = $1`,
+	models.LookupTransaction: `AND p.transaction = $1`,
+	models.LookupChrtID:      `AND o.order_uid IN (SELECT order_uid FROM items WHERE chrt_id = $1)`,
+	models.LookupRid:         `AND o.order_uid IN (SELECT order_uid FROM items WHERE rid = $1)`,
+	models.LookupNmID:        `AND o.order_uid IN (SELECT order_uid FROM items WHERE nm_id = $1)`,
+}
+
+// Lookup returns the most recent orders (up to maxPageSize) whose key matches value.
+func (r *OrderRepo) Lookup(ctx context.Context, key models.LookupKey, value string) (_ []models.Order, err error) {
+	ctx, span := tracer.Start(ctx, "OrderRepo.Lookup",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("lookup.key", string(key))),
+	)
+	defer func() { endSpan(span, err) }()
+
+	cond, ok := lookupConditions[key]
+	if !ok {
+		return nil, errors.Errorf("unknown lookup key %q", key)
+	}
+
+	var orders []models.Order
+	err = r.read(ctx, "", func(q sqlx.QueryerContext) (err error) {
+		orders, err = r.selectOrders(ctx, q,
+			cond+` ORDER BY o.date_created DESC, o.order_uid DESC LIMIT $2`, value, maxPageSize)
+		return err
+	})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "lookup orders by %s", key)
+	}
+	return orders, nil
+}
+
+// CustomerHistory returns summary totals over all orders of a customer and
+// up to maxPageSize of the most recent orders. It returns nil if the customer
+// has no orders.
+func (r *OrderRepo) CustomerHistory(ctx context.Context, customerID string) (_ *models.CustomerHistory, err error) {
+	ctx, span := tracer.Start(ctx, "OrderRepo.CustomerHistory",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("customer_id", customerID)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	// Orders without a payment row count, as selectOrders returns them too,
+	// but add nothing to the totals.
+	const selTotals = `
+		SELECT COALESCE(p.currency, '') AS currency, COUNT(*) AS orders,
+		       COALESCE(SUM(p.amount), 0)::bigint AS total,
+		       MIN(o.date_created) AS first_order, MAX(o.date_created) AS last_order
+		FROM orders o
+		LEFT JOIN payments p ON p.order_uid = o.order_uid
+		WHERE o.customer_id = $1 AND o.deleted_at IS NULL
+		GROUP BY 1
+		ORDER BY 1
+	`
+	const selReporting = `
+		SELECT p.reporting_currency AS currency, COALESCE(SUM(p.reporting_amount), 0)::bigint AS amount
+		FROM orders o
+		LEFT JOIN payments p ON p.order_uid = o.order_uid
+		WHERE o.customer_id = $1 AND o.deleted_at IS NULL AND p.reporting_currency IS NOT NULL
+		GROUP BY p.reporting_currency
+		ORDER BY p.reporting_currency
+	`
+	var reporting []struct {
+		Currency string `db:"currency"`
+		Amount   int64  `db:"amount"`
+	}
+	var totals []struct {
+		Currency   string    `db:"currency"`
+		Orders     int       `db:"orders"`
+		Total      int64     `db:"total"`
+		FirstOrder time.Time `db:"first_order"`
+		LastOrder  time.Time `db:"last_order"`
+	}
+	// Totals and orders are read from the same node so they agree with each other.
+	var orders []models.Order
+	err = r.read(ctx, customerKey(customerID), func(q sqlx.QueryerContext) (err error) {
+		totals = totals[:0]
+		if err = sqlx.SelectContext(ctx, q, &totals, selTotals, customerID); err != nil {
+			return errors.WithMessage(moneyOverflow(err), "select customer totals")
+		}
+		if len(totals) == 0 {
+			return nil
+		}
+		reporting = reporting[:0]
+		if err = sqlx.SelectContext(ctx, q, &reporting, selReporting, customerID); err != nil {
+			return errors.WithMessage(moneyOverflow(err), "select customer reporting totals")
+		}
+		orders, err = r.selectOrders(ctx, q,
+			`AND o.customer_id = $1 ORDER BY o.date_created DESC, o.order_uid DESC LIMIT $2`, customerID, maxPageSize)
+		return errors.WithMessage(err, "select customer orders")
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(totals) == 0 {
+		return nil, nil
+	}
+
+	h := &models.CustomerHistory{
+		CustomerID:     customerID,
+		TotalSpent:     make([]models.Money, 0, len(totals)),
+		TotalReporting: make([]models.Money, 0, len(reporting)),
+	}
+	for _, m := range reporting {
+		h.TotalReporting = append(h.TotalReporting, models.Money{Amount: m.Amount, Currency: m.Currency})
+	}
+	for _, t := range totals {
+		h.OrderCount += t.Orders
+		if t.Currency != "" {
+			h.TotalSpent = append(h.TotalSpent, models.Money{Amount: t.Total, Currency: t.Currency})
+		}
+		if h.FirstOrderAt.IsZero() || t.FirstOrder.Before(h.FirstOrderAt) {
+			h.FirstOrderAt = t.FirstOrder
+		}
+		if t.LastOrder.After(h.LastOrderAt) {
+			h.LastOrderAt = t.LastOrder
+		}
+	}
+
+	h.Orders = orders
+	return h, nil
+}
+
+// moneyOverflow turns a sum that does not fit a BIGINT amount into
+// models.ErrMoneyOverflow.
+func moneyOverflow(err error) error {
+	if postgres.IsOutOfRange(err) {
+		return models.ErrMoneyOverflow
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+	"wb/internal/generator"
+)
+
+// TestCustomerHistoryWithoutPayment checks that orders missing their payment
+// row, which the order list shows, are counted in the customer history too.
+func TestCustomerHistoryWithoutPayment(t *testing.T) {
+	db := testDB(t)
+	repo := newTestOrderRepo(db)
+	ctx := context.Background()
+
+	gen := generator.New(time.Now().UnixNano(), time.Now().Add(-time.Hour).Truncate(time.Second))
+	customerID := "no-payment-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	o := gen.Order(1)
+	o.CustomerId = customerID
+	if _, err := repo.Upsert(ctx, &o, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `DELETE FROM payments WHERE order_uid = $1`, o.OrderUID); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := repo.CustomerHistory(ctx, customerID)
+	if err != nil || h == nil {
+		t.Fatalf("customer history = %v, %v; want the order without a payment", h, err)
+	}
+	if h.OrderCount != 1 || len(h.Orders) != 1 || len(h.TotalSpent) != 0 {
+		t.Fatalf("history = %d orders, %d listed, spent %v; want 1, 1 and nothing", h.OrderCount, len(h.Orders), h.TotalSpent)
+	}
+}
@@ -3,31 +3,68 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"time"
 	"wb/internal/models"
+	"wb/pkg/postgres"
 )
 
+var tracer = otel.Tracer("wb/internal/repository")
+
 type OrderRepo struct {
 	db         *sqlx.DB
 	deliveries *DeliveryRepo
 	payments   *PaymentRepo
 	items      *ItemRepo
+	outbox     *OutboxRepo
+	history    *HistoryRepo
+	partitions *PartitionRepo
+	replicas   *postgres.ReplicaSet
+	recent     *recentWrites
 }
 
-func NewOrderRepo(db *sqlx.DB, d *DeliveryRepo, p *PaymentRepo, i *ItemRepo) *OrderRepo {
+// NewOrderRepo builds the order repository on top of the primary db. With a
+// non-nil replica set, plain reads go to replicas while writes and reads of
+// orders written within the replicas' max lag stay on the primary. With a
+// non-nil partition repo, Get falls back to archived partitions.
+func NewOrderRepo(db *sqlx.DB, d *DeliveryRepo, p *PaymentRepo, i *ItemRepo, e *OutboxRepo, h *HistoryRepo,
+	a *PartitionRepo, replicas *postgres.ReplicaSet) *OrderRepo {
+	var window time.Duration
+	if replicas != nil {
+		window = replicas.MaxLag()
+	}
 	return &OrderRepo{
 		db:         db,
 		deliveries: d,
 		payments:   p,
 		items:      i,
+		outbox:     e,
+		history:    h,
+		partitions: a,
+		replicas:   replicas,
+		recent:     newRecentWrites(window),
 	}
 }
 
-func (r *OrderRepo) Upsert(ctx context.Context, o models.Order) (order_uid string, err error) {
+// Upsert inserts or replaces an order and returns its new row version.
+// A soft-deleted order is not overwritten: models.ErrOrderDeleted is returned.
+// With expectedVersion > 0 the write only succeeds if the stored version
+// matches, otherwise a *models.ConflictError is returned; 0 writes unconditionally
+// and models.AnyVersion only if the order exists.
+// The stored version and statuses of the order and its items are set on o.
+func (r *OrderRepo) Upsert(ctx context.Context, o *models.Order, expectedVersion int64) (version int64, err error) {
+	ctx, span := startSpan(ctx, "OrderRepo.Upsert", o.OrderUID)
+	defer func() { endSpan(span, err) }()
+
 	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
 	if err != nil {
-		return "", errors.WithMessage(err, "begin tx")
+		return 0, errors.WithMessage(err, "begin tx")
 	}
 	defer func() {
 		if err != nil {
@@ -35,6 +72,32 @@ func (r *OrderRepo) Upsert(ctx context.Context, o models.Order) (order_uid strin
 		}
 	}()
 
+	if err = lockOrderTx(ctx, tx, o.OrderUID, o.DateCreated); err != nil {
+		return 0, err
+	}
+
+	var stored struct {
+		CustomerId string `db:"customer_id"`
+		Deleted    bool   `db:"deleted"`
+	}
+	const selectStored = `SELECT customer_id, deleted_at IS NOT NULL AS deleted FROM orders WHERE order_uid = $1`
+	err = tx.GetContext(ctx, &stored, selectStored, o.OrderUID)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, errors.WithMessage(err, "select stored order")
+	}
+	if stored.Deleted {
+		err = models.ErrOrderDeleted
+		return 0, err
+	}
+	if expectedVersion == models.AnyVersion {
+		if err == sql.ErrNoRows {
+			err = &models.ConflictError{OrderUID: o.OrderUID, Expected: expectedVersion}
+			return 0, err
+		}
+		expectedVersion = 0
+	}
+	previous := stored.CustomerId
+
 	const upsertOrder = `
 		INSERT INTO orders (
 			order_uid, track_number, entry, locale, internal_signature, customer_id,
@@ -42,7 +105,7 @@ func (r *OrderRepo) Upsert(ctx context.Context, o models.Order) (order_uid strin
 		) VALUES (
 			$1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11
 		)
-		ON CONFLICT (order_uid) DO UPDATE SET
+		ON CONFLICT (order_uid, date_created) DO UPDATE SET
 			track_number=$2,
 			entry=$3,
 			locale=$4,
@@ -51,84 +114,259 @@ func (r *OrderRepo) Upsert(ctx context.Context, o models.Order) (order_uid strin
 			delivery_service=$7,
 			shardkey=$8,
 			sm_id=$9,
-			date_created=$10,
-			oof_shard=$11
+			oof_shard=$11,
+			version=orders.version + 1
+		WHERE $12 = 0 OR orders.version = $12
+		RETURNING (xmax = 0) AS inserted, version, status
 	`
-	if _, err = tx.ExecContext(ctx, upsertOrder,
+	var inserted bool
+	err = tx.QueryRowxContext(ctx, upsertOrder,
 		o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerId,
-		o.DeliveryService, o.ShardKey, o.SmId, o.DateCreated, o.OofShard,
-	); err != nil {
-		return "", errors.WithMessage(err, "upsert orders")
+		o.DeliveryService, o.ShardKey, o.SmId, o.DateCreated, o.OofShard, expectedVersion,
+	).Scan(&inserted, &version, &o.Status)
+	if err == sql.ErrNoRows {
+		var actual int64
+		if err = tx.GetContext(ctx, &actual, `SELECT version FROM orders WHERE order_uid = $1`, o.OrderUID); err != nil {
+			return 0, errors.WithMessage(err, "select order version")
+		}
+		err = &models.ConflictError{OrderUID: o.OrderUID, Expected: expectedVersion, Actual: actual}
+		return 0, err
+	}
+	if err != nil {
+		return 0, errors.WithMessage(err, "upsert orders")
+	}
+	if inserted && expectedVersion > 0 {
+		err = &models.ConflictError{OrderUID: o.OrderUID, Expected: expectedVersion}
+		return 0, err
+	}
+	o.Version = version
+	o.PreviousCustomerId = ""
+	if previous != "" && previous != o.CustomerId {
+		o.PreviousCustomerId = previous
 	}
 
 	o.Delivery.OrderUID = o.OrderUID
 	if _, err = r.deliveries.UpsertTx(ctx, tx, o.Delivery); err != nil {
-		return "", errors.WithMessage(err, "upsert delivery")
+		return 0, errors.WithMessage(err, "upsert delivery")
 	}
 
 	o.Payment.OrderUID = o.OrderUID
 	if _, err = r.payments.UpsertTx(ctx, tx, o.Payment); err != nil {
-		return "", errors.WithMessage(err, "upsert payment")
-	}
-
-	if _, err = r.items.DeleteByOrderUIDTx(ctx, tx, o.OrderUID); err != nil {
-		return "", errors.WithMessage(err, "delete items by order_uid")
+		return 0, errors.WithMessage(err, "upsert payment")
 	}
 
-	for _, it := range o.Items {
-		it.OrderUID = o.OrderUID
+	o.Items = uniqueItems(o.Items)
+	items := make([]models.Item, len(o.Items))
+	for i, it := range o.Items {
 		if it.TrackNumber == "" {
 			it.TrackNumber = o.TrackNumber
 		}
-		if _, err = r.items.UpsertTx(ctx, tx, it); err != nil {
-			return "", errors.WithMessage(err, "upsert item")
+		items[i] = it
+	}
+	itemsAt := o.DateCreated
+	if !inserted {
+		itemsAt = time.Now()
+	}
+	if err = r.items.SyncTx(ctx, tx, o.OrderUID, items, itemsAt); err != nil {
+		return 0, errors.WithMessage(err, "sync items")
+	}
+	for i := range o.Items {
+		o.Items[i].FulfillmentStatus, o.Items[i].StatusChangedAt = items[i].FulfillmentStatus, items[i].StatusChangedAt
+	}
+
+	eventType := models.OrderUpdated
+	if inserted {
+		eventType = models.OrderCreated
+		initial := models.StatusRecord{OrderUID: o.OrderUID, Status: o.Status, ChangedAt: o.DateCreated}
+		if err = insertStatusTx(ctx, tx, initial); err != nil {
+			return 0, err
 		}
 	}
+	if err = deriveStatusTx(ctx, tx, o, itemsAt); err != nil {
+		return 0, err
+	}
+	if err = r.outbox.InsertTx(ctx, tx, o.OrderUID, eventType, o); err != nil {
+		return 0, errors.WithMessage(err, "insert order event")
+	}
+	if err = r.history.InsertTx(ctx, tx, o.OrderUID, eventType, o); err != nil {
+		return 0, errors.WithMessage(err, "insert order history")
+	}
 
 	if err = tx.Commit(); err != nil {
-		return "", errors.WithMessage(err, "commit")
+		return 0, errors.WithMessage(err, "commit")
+	}
+	keys := []string{o.OrderUID, customerKey(o.CustomerId)}
+	if o.PreviousCustomerId != "" {
+		keys = append(keys, customerKey(o.PreviousCustomerId))
 	}
-	return o.OrderUID, nil
+	r.recent.mark(keys...)
+	return version, nil
 }
 
-func (r *OrderRepo) Get(ctx context.Context, orderUID string) (*models.Order, error) {
-	var o models.Order
+func (r *OrderRepo) Get(ctx context.Context, orderUID string) (_ *models.Order, err error) {
+	ctx, span := startSpan(ctx, "OrderRepo.Get", orderUID)
+	defer func() { endSpan(span, err) }()
 
-	const selOrder = `
-		SELECT order_uid, track_number, entry, locale, internal_signature, customer_id,
-		       delivery_service, shardkey, sm_id, date_created, oof_shard
-		FROM orders
-		WHERE order_uid = $1
-	`
-	if err := r.db.GetContext(ctx, &o, selOrder, orderUID); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
+	var orders []models.Order
+	err = r.read(ctx, orderUID, func(q sqlx.QueryerContext) (err error) {
+		orders, err = r.selectOrders(ctx, q, `AND o.order_uid = $1`, orderUID)
+		return err
+	})
+	if err != nil {
 		return nil, errors.WithMessage(err, "select order")
 	}
+	if len(orders) == 0 && r.partitions != nil {
+		return r.partitions.GetArchived(ctx, orderUID)
+	}
+	if len(orders) == 0 {
+		return nil, nil
+	}
+	return &orders[0], nil
+}
 
-	if d, err := r.deliveries.Get(ctx, models.Delivery{OrderUID: orderUID}); err != nil {
+func (r *OrderRepo) History(ctx context.Context, orderUID string) (_ []models.OrderVersion, err error) {
+	ctx, span := startSpan(ctx, "OrderRepo.History", orderUID)
+	defer func() { endSpan(span, err) }()
+
+	return r.history.List(ctx, orderUID)
+}
+
+func (r *OrderRepo) GetAsOf(ctx context.Context, orderUID string, t time.Time) (_ *models.Order, err error) {
+	ctx, span := startSpan(ctx, "OrderRepo.GetAsOf", orderUID)
+	defer func() { endSpan(span, err) }()
+
+	v, err := r.history.AsOf(ctx, orderUID, t)
+	if err != nil || v == nil {
 		return nil, err
-	} else if d != nil {
-		o.Delivery = *d
 	}
 
-	if p, err := r.payments.Get(ctx, orderUID); err != nil {
-		return nil, err
-	} else if p != nil {
-		o.Payment = *p
+	var o models.Order
+	if err = json.Unmarshal(v.Snapshot, &o); err != nil {
+		return nil, errors.WithMessage(err, "decode order snapshot")
+	}
+	return &o, nil
+}
+
+// Delete soft-deletes an order: it disappears from all reads but stays in
+// the database until restored or purged. It returns the customer the order
+// belonged to; ok is false if there is no live order with this uid.
+func (r *OrderRepo) Delete(ctx context.Context, orderUID string) (customerID string, ok bool, err error) {
+	ctx, span := startSpan(ctx, "OrderRepo.Delete", orderUID)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return "", false, errors.WithMessage(err, "begin tx")
 	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
 
-	items, err := r.items.GetByOrderUID(ctx, orderUID)
+	const softDelete = `
+		UPDATE orders SET deleted_at = now(), version = version + 1
+		WHERE order_uid = $1 AND deleted_at IS NULL
+		RETURNING customer_id
+	`
+	err = tx.GetContext(ctx, &customerID, softDelete, orderUID)
+	if err == sql.ErrNoRows {
+		return "", false, tx.Rollback()
+	}
 	if err != nil {
+		return "", false, errors.WithMessage(err, "delete order")
+	}
+
+	payload := map[string]string{"order_uid": orderUID}
+	if err = r.outbox.InsertTx(ctx, tx, orderUID, models.OrderDeleted, payload); err != nil {
+		return "", false, errors.WithMessage(err, "insert order event")
+	}
+	if err = r.history.InsertTx(ctx, tx, orderUID, models.OrderDeleted, payload); err != nil {
+		return "", false, errors.WithMessage(err, "insert order history")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", false, errors.WithMessage(err, "commit")
+	}
+	r.recent.mark(orderUID, customerKey(customerID))
+	return customerID, true, nil
+}
+
+// Restore undoes a soft delete and returns the restored order, or nil if
+// there is no soft-deleted order with this uid.
+func (r *OrderRepo) Restore(ctx context.Context, orderUID string) (_ *models.Order, err error) {
+	ctx, span := startSpan(ctx, "OrderRepo.Restore", orderUID)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, errors.WithMessage(err, "begin tx")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	const restore = `
+		UPDATE orders SET deleted_at = NULL, version = version + 1
+		WHERE order_uid = $1 AND deleted_at IS NOT NULL
+	`
+	res, err := tx.ExecContext(ctx, restore, orderUID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "restore order")
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		return nil, tx.Rollback()
+	}
+
+	orders, err := r.selectOrders(ctx, tx, `AND o.order_uid = $1`, orderUID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "select restored order")
+	}
+	if len(orders) == 0 {
+		err = errors.Errorf("restored order %s not found", orderUID)
 		return nil, err
 	}
-	o.Items = items
+	o := orders[0]
 
+	if err = r.outbox.InsertTx(ctx, tx, orderUID, models.OrderRestored, o); err != nil {
+		return nil, errors.WithMessage(err, "insert order event")
+	}
+	if err = r.history.InsertTx(ctx, tx, orderUID, models.OrderRestored, o); err != nil {
+		return nil, errors.WithMessage(err, "insert order history")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WithMessage(err, "commit")
+	}
+	r.recent.mark(orderUID, customerKey(o.CustomerId))
 	return &o, nil
 }
 
-func (r *OrderRepo) Delete(ctx context.Context, orderUID string) (int64, error) {
+// Purge hard-deletes orders that were soft-deleted more than olderThan ago.
+// Their history is kept.
+func (r *OrderRepo) Purge(ctx context.Context, olderThan time.Duration) (_ int64, err error) {
+	ctx, span := tracer.Start(ctx, "OrderRepo.Purge", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { endSpan(span, err) }()
+
+	const purge = `
+		WITH purged AS (
+			DELETE FROM orders
+			WHERE deleted_at IS NOT NULL AND deleted_at < now() - make_interval(secs => $1)
+			RETURNING order_uid
+		),
+		deliveries AS (
+			DELETE FROM deliveries WHERE order_uid IN (SELECT order_uid FROM purged)
+		),
+		payments AS (
+			DELETE FROM payments WHERE order_uid IN (SELECT order_uid FROM purged)
+		),
+		items AS (
+			DELETE FROM items WHERE order_uid IN (SELECT order_uid FROM purged)
+		)
+		SELECT COUNT(*) FROM purged
+	`
 	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
 	if err != nil {
 		return 0, errors.WithMessage(err, "begin tx")
@@ -138,57 +376,136 @@ func (r *OrderRepo) Delete(ctx context.Context, orderUID string) (int64, error)
 			_ = tx.Rollback()
 		}
 	}()
-
-	res, err := tx.ExecContext(ctx, `DELETE FROM orders WHERE order_uid=$1`, orderUID)
-	if err != nil {
-		return 0, errors.WithMessage(err, "delete order")
+	if err = noTimeoutTx(ctx, tx); err != nil {
+		return 0, err
 	}
-	aff, _ := res.RowsAffected()
 
+	var n int64
+	if err = tx.GetContext(ctx, &n, purge, olderThan.Seconds()); err != nil {
+		return 0, errors.WithMessage(err, "purge deleted orders")
+	}
 	if err = tx.Commit(); err != nil {
 		return 0, errors.WithMessage(err, "commit")
 	}
-	return aff, nil
+	return n, nil
 }
 
-func (r *OrderRepo) GetLastOrders(ctx context.Context, n int) ([]models.Order, error) {
-	const selOrders = `
-		SELECT order_uid, track_number, entry, locale, internal_signature, customer_id,
-		       delivery_service, shardkey, sm_id, date_created, oof_shard
-		FROM orders
-		ORDER BY date_created DESC, order_uid DESC
-		LIMIT $1
-	`
+func (r *OrderRepo) GetLastOrders(ctx context.Context, n int) (_ []models.Order, err error) {
+	ctx, span := tracer.Start(ctx, "OrderRepo.GetLastOrders",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("limit", n)),
+	)
+	defer func() { endSpan(span, err) }()
 
 	var orders []models.Order
-	if err := r.db.SelectContext(ctx, &orders, selOrders, n); err != nil {
+	err = r.read(ctx, "", func(q sqlx.QueryerContext) (err error) {
+		orders, err = r.selectOrders(ctx, q, `ORDER BY o.date_created DESC, o.order_uid DESC LIMIT $1`, n)
+		return err
+	})
+	if err != nil {
 		return nil, errors.WithMessage(err, "select last orders")
 	}
-	if len(orders) == 0 {
-		return nil, nil
+	return orders, nil
+}
+
+// orderLockClass namespaces the per-order advisory locks taken by writers.
+const orderLockClass = "1"
+
+// noTimeoutTx lifts PG_STATEMENT_TIMEOUT for the rest of tx, for bulk loads
+// and partition maintenance that may legitimately run for minutes.
+func noTimeoutTx(ctx context.Context, tx *sqlx.Tx) error {
+	if _, err := tx.ExecContext(ctx, `SET LOCAL statement_timeout = 0`); err != nil {
+		return errors.WithMessage(err, "disable statement timeout")
 	}
+	return nil
+}
 
-	for i := range orders {
-		uid := orders[i].OrderUID
+// lockOrderTx serialises writers of orderUID and moves an existing row to
+// the partition of dateCreated. The primary key of the partitioned orders
+// table includes date_created, so the lock is what keeps order_uid unique.
+func lockOrderTx(ctx context.Context, tx *sqlx.Tx, orderUID string, dateCreated time.Time) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(`+orderLockClass+`, hashtext($1))`, orderUID); err != nil {
+		return errors.WithMessage(err, "lock order")
+	}
+	const move = `UPDATE orders SET date_created = $2 WHERE order_uid = $1 AND date_created <> $2`
+	if _, err := tx.ExecContext(ctx, move, orderUID, dateCreated); err != nil {
+		return errors.WithMessage(err, "move order partition")
+	}
+	return nil
+}
 
-		if d, err := r.deliveries.Get(ctx, models.Delivery{OrderUID: uid}); err != nil {
-			return nil, err
-		} else if d != nil {
-			orders[i].Delivery = *d
-		}
+// orderSelect loads live (not soft-deleted) orders together with their
+// delivery and payment; items are fetched separately in one batch by
+// selectOrders. Callers append "AND ..." conditions and ORDER BY/LIMIT.
+const orderSelect = `SELECT ` + orderColumns + `
+	FROM orders o
+	` + orderJoins + `
+	WHERE o.deleted_at IS NULL
+`
 
-		if p, err := r.payments.Get(ctx, uid); err != nil {
-			return nil, err
-		} else if p != nil {
-			orders[i].Payment = *p
-		}
+// orderJoins left joins the delivery d and payment p of orders o, so an
+// order missing either row is still found, with a zero value in its place.
+const orderJoins = `LEFT JOIN deliveries d ON d.order_uid = o.order_uid
+	LEFT JOIN payments p ON p.order_uid = o.order_uid`
 
-		items, err := r.items.GetByOrderUID(ctx, uid)
-		if err != nil {
-			return nil, err
-		}
-		orders[i].Items = items
+// orderColumns are the columns of an order row aliased as o, its delivery d and payment p.
+const orderColumns = `
+	o.order_uid, o.track_number, o.entry, o.locale, o.internal_signature, o.customer_id,
+	o.delivery_service, o.shardkey, o.sm_id, o.date_created, o.oof_shard, o.status, o.version,
+	COALESCE(d.order_uid, '') AS "delivery.order_uid", COALESCE(d.name, '') AS "delivery.name",
+	COALESCE(d.phone, '') AS "delivery.phone", COALESCE(d.zip, '') AS "delivery.zip",
+	COALESCE(d.city, '') AS "delivery.city", COALESCE(d.address, '') AS "delivery.address",
+	COALESCE(d.region, '') AS "delivery.region", COALESCE(d.email, '') AS "delivery.email",
+	COALESCE(p.order_uid, '') AS "payment.order_uid", COALESCE(p.transaction, '') AS "payment.transaction",
+	COALESCE(p.request_id, '') AS "payment.request_id", COALESCE(p.currency, '') AS "payment.currency",
+	COALESCE(p.provider, '') AS "payment.provider", COALESCE(p.amount, 0) AS "payment.amount",
+	COALESCE(p.payment_dt, 0) AS "payment.payment_dt", COALESCE(p.bank, '') AS "payment.bank",
+	COALESCE(p.delivery_cost, 0) AS "payment.delivery_cost", COALESCE(p.goods_total, 0) AS "payment.goods_total",
+	COALESCE(p.custom_fee, 0) AS "payment.custom_fee",
+	COALESCE(p.reporting_amount, 0) AS "payment.reporting_amount",
+	COALESCE(p.reporting_currency, '') AS "payment.reporting_currency"
+`
+
+// selectOrders runs orderSelect with the given conditions/ORDER BY/LIMIT tail and
+// attaches items, costing two round-trips regardless of the number of orders.
+func (r *OrderRepo) selectOrders(ctx context.Context, q sqlx.QueryerContext, tail string, args ...any) ([]models.Order, error) {
+	var orders []models.Order
+	if err := sqlx.SelectContext(ctx, q, &orders, orderSelect+tail, args...); err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, nil
 	}
 
+	uids := make([]string, len(orders))
+	for i := range orders {
+		uids[i] = orders[i].OrderUID
+	}
+	items, err := r.items.GetByOrderUIDs(ctx, q, uids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		orders[i].Items = items[orders[i].OrderUID]
+		orders[i].FillCurrency()
+	}
 	return orders, nil
 }
+
+func startSpan(ctx context.Context, name, orderUID string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("order_uid", orderUID),
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
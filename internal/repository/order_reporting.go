@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"wb/internal/models"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// reportingSelect reads payments with their reporting amounts.
+const reportingSelect = `
+	SELECT order_uid, transaction, request_id, currency, provider, amount,
+	       payment_dt, bank, delivery_cost, goods_total, custom_fee,
+	       COALESCE(reporting_amount, 0) AS reporting_amount,
+	       COALESCE(reporting_currency, '') AS reporting_currency
+	FROM payments
+`
+
+// UnconvertedPayments returns up to limit payments with order_uid after
+// after that have no reporting amount, ordered by order_uid.
+func (r *OrderRepo) UnconvertedPayments(ctx context.Context, after string, limit int) ([]models.Payment, error) {
+	const q = reportingSelect + `
+		WHERE reporting_currency IS NULL AND order_uid > $1
+		ORDER BY order_uid
+		LIMIT $2
+	`
+	return r.selectPayments(ctx, "select unconverted payments", q, after, limit)
+}
+
+// PaymentsSince returns up to limit payments in currency, or in any currency
+// if it is empty, made at or after since, with order_uid after after,
+// ordered by order_uid.
+func (r *OrderRepo) PaymentsSince(ctx context.Context, currency string, since time.Time, after string, limit int) ([]models.Payment, error) {
+	args := []any{since.Unix(), after}
+	where := "payment_dt >= $1 AND order_uid > $2"
+	if currency != "" {
+		args = append(args, currency)
+		where += fmt.Sprintf(" AND currency = $%d", len(args))
+	}
+	args = append(args, limit)
+	q := reportingSelect + "WHERE " + where + fmt.Sprintf(" ORDER BY order_uid LIMIT $%d", len(args))
+	return r.selectPayments(ctx, "select payments since", q, args...)
+}
+
+func (r *OrderRepo) selectPayments(ctx context.Context, what, q string, args ...any) ([]models.Payment, error) {
+	var out []models.Payment
+	if err := r.db.SelectContext(ctx, &out, q, args...); err != nil {
+		return nil, errors.WithMessage(err, what)
+	}
+	for i := range out {
+		out[i].FillCurrency()
+	}
+	return out, nil
+}
+
+// SetReporting stores the reporting amounts of payments and returns the
+// customer of every order it changed, by order_uid. A payment whose amount,
+// currency or payment time changed since it was read is skipped: the write
+// that changed it set its reporting amount already. Reporting amounts are
+// derived from rates, so this is not an order change: it neither bumps
+// versions nor records history.
+func (r *OrderRepo) SetReporting(ctx context.Context, payments []models.Payment) (map[string]string, error) {
+	if len(payments) == 0 {
+		return nil, nil
+	}
+	uids := make([]string, len(payments))
+	amounts := make([]int64, len(payments))
+	currencies := make([]string, len(payments))
+	paid := make([]int64, len(payments))
+	paidCurrencies := make([]string, len(payments))
+	paidAt := make([]int64, len(payments))
+	for i, p := range payments {
+		uids[i], amounts[i], currencies[i] = p.OrderUID, p.ReportingAmount.Amount, p.ReportingCurrency
+		paid[i], paidCurrencies[i], paidAt[i] = p.Amount.Amount, p.Currency, p.PaymentDt
+	}
+
+	const q = `
+		UPDATE payments p
+		SET reporting_amount   = CASE WHEN u.currency <> '' THEN u.amount END,
+		    reporting_currency = NULLIF(u.currency, '')
+		FROM unnest($1::text[], $2::bigint[], $3::text[], $4::bigint[], $5::text[], $6::bigint[])
+		         AS u(order_uid, amount, currency, paid, paid_currency, payment_dt),
+		     orders o
+		WHERE p.order_uid = u.order_uid AND o.order_uid = p.order_uid
+		  AND p.amount = u.paid AND p.currency = u.paid_currency AND p.payment_dt = u.payment_dt
+		RETURNING p.order_uid, o.customer_id
+	`
+	var rows []struct {
+		OrderUID   string `db:"order_uid"`
+		CustomerID string `db:"customer_id"`
+	}
+	if err := r.db.SelectContext(ctx, &rows, q, pq.Array(uids), pq.Array(amounts), pq.Array(currencies),
+		pq.Array(paid), pq.Array(paidCurrencies), pq.Array(paidAt)); err != nil {
+		return nil, errors.WithMessage(err, "set reporting amounts")
+	}
+	customers := make(map[string]string, len(rows))
+	keys := make([]string, 0, 2*len(rows))
+	for _, row := range rows {
+		customers[row.OrderUID] = row.CustomerID
+		keys = append(keys, row.OrderUID, customerKey(row.CustomerID))
+	}
+	r.recent.mark(keys...)
+	return customers, nil
+}
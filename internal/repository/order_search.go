@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"wb/internal/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const maxSearchTerms = 8
+
+var searchTerm = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// searchTerms splits free text into lower-cased words; each is matched as a
+// prefix so that partial names and addresses are found.
+func searchTerms(q string) []string {
+	return searchTerm.FindAllString(strings.ToLower(q), maxSearchTerms)
+}
+
+// prefixQueries returns a prefix tsquery per term.
+func prefixQueries(terms []string) []string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = t + ":*"
+	}
+	return parts
+}
+
+// Search finds live orders containing every word of q somewhere in their
+// delivery (name, address, city, region, email) or items (name, brand), so
+// words may match different rows, best matches first.
+func (r *OrderRepo) Search(ctx context.Context, q string, limit int) (_ []models.SearchHit, err error) {
+	ctx, span := tracer.Start(ctx, "OrderRepo.Search",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("limit", limit)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	// Every term is looked up separately in the search indexes of deliveries
+	// and items; an order matches when it has a hit for each term. Rank and
+	// highlights use the terms OR-ed together.
+	const sel = `
+		WITH terms AS (
+			SELECT n, to_tsquery('simple', t) AS q
+			FROM unnest($1::text[]) WITH ORDINALITY AS u(t, n)
+		),
+		term_hits AS (
+			SELECT t.n, d.order_uid FROM terms t JOIN deliveries d ON d.search @@ t.q
+			UNION
+			SELECT t.n, i.order_uid FROM terms t JOIN items i ON i.search @@ t.q
+		),
+		matched AS (
+			SELECT order_uid FROM term_hits
+			GROUP BY order_uid
+			HAVING COUNT(DISTINCT n) = cardinality($1::text[])
+		),
+		q AS (
+			SELECT to_tsquery('simple', array_to_string($1::text[], ' | ')) AS q
+		),
+		ranked AS (
+			SELECT m.order_uid,
+			       COALESCE((SELECT ts_rank(d.search, q.q) FROM deliveries d
+			                 WHERE d.order_uid = m.order_uid AND d.search @@ q.q), 0) +
+			       COALESCE((SELECT SUM(ts_rank(i.search, q.q)) FROM items i
+			                 WHERE i.order_uid = m.order_uid AND i.search @@ q.q), 0) AS rank
+			FROM matched m, q
+		)
+		SELECT r.order_uid, r.rank,
+		       CASE WHEN d.search @@ q.q THEN
+		           ts_headline('simple', concat_ws(', ', d.name, d.email, d.city, d.address), q.q, $3)
+		       END AS delivery_headline,
+		       (SELECT string_agg(ts_headline('simple', i.name || ' ' || i.brand, q.q, $3), '; ' ORDER BY i.id)
+		        FROM items i
+		        WHERE i.order_uid = r.order_uid AND i.search @@ q.q) AS items_headline
+		FROM ranked r
+		CROSS JOIN q
+		JOIN orders o ON o.order_uid = r.order_uid AND o.deleted_at IS NULL
+		LEFT JOIN deliveries d ON d.order_uid = r.order_uid
+		ORDER BY r.rank DESC, o.date_created DESC, r.order_uid
+		LIMIT $2
+	`
+	const headlineOpts = "StartSel=<mark>, StopSel=</mark>, HighlightAll=true"
+
+	var rows []struct {
+		OrderUID         string  `db:"order_uid"`
+		Rank             float64 `db:"rank"`
+		DeliveryHeadline *string `db:"delivery_headline"`
+		ItemsHeadline    *string `db:"items_headline"`
+	}
+	var orders []models.Order
+	err = r.read(ctx, "", func(db sqlx.QueryerContext) error {
+		if err := sqlx.SelectContext(ctx, db, &rows, sel, pq.Array(prefixQueries(terms)), limit, headlineOpts); err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		uids := make([]string, len(rows))
+		for i, row := range rows {
+			uids[i] = row.OrderUID
+		}
+		var err error
+		orders, err = r.selectOrders(ctx, db, `AND o.order_uid = ANY($1)`, pq.Array(uids))
+		return err
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "search orders")
+	}
+
+	byUID := make(map[string]models.Order, len(orders))
+	for _, o := range orders {
+		byUID[o.OrderUID] = o
+	}
+	hits := make([]models.SearchHit, 0, len(rows))
+	for _, row := range rows {
+		o, ok := byUID[row.OrderUID]
+		if !ok {
+			continue
+		}
+		h := models.SearchHit{Order: o, Rank: row.Rank}
+		for _, hl := range []*string{row.DeliveryHeadline, row.ItemsHeadline} {
+			if hl != nil && *hl != "" {
+				h.Highlights = append(h.Highlights, *hl)
+			}
+		}
+		hits = append(hits, h)
+	}
+	return hits, nil
+}
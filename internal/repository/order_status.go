@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"wb/internal/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Fulfillment returns the current status of a live order and its items,
+// read from the primary, or nil if there is no such order.
+func (r *OrderRepo) Fulfillment(ctx context.Context, orderUID string) (_ *models.Fulfillment, err error) {
+	ctx, span := startSpan(ctx, "OrderRepo.Fulfillment", orderUID)
+	defer func() { endSpan(span, err) }()
+
+	var f models.Fulfillment
+	const selOrder = `SELECT order_uid, status, version FROM orders WHERE order_uid = $1 AND deleted_at IS NULL`
+	err = r.db.GetContext(ctx, &f, selOrder, orderUID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "select order status")
+	}
+
+	const selItems = `
+		SELECT order_uid, chrt_id, rid, name, fulfillment_status, status_changed_at
+		FROM items
+		WHERE order_uid = $1
+		ORDER BY id
+	`
+	if err = r.db.SelectContext(ctx, &f.Items, selItems, orderUID); err != nil {
+		return nil, errors.WithMessage(err, "select item statuses")
+	}
+	return &f, nil
+}
+
+// SetStatus stores a status update of a live order: the order and item
+// statuses, their history, the order history and the outbox. It returns the
+// updated order, or nil if the order is gone or no longer at u.Version;
+// deciding whether the update is allowed is up to the caller.
+func (r *OrderRepo) SetStatus(ctx context.Context, u models.StatusUpdate) (_ *models.Order, err error) {
+	orderUID := u.Order.OrderUID
+	ctx, span := startSpan(ctx, "OrderRepo.SetStatus", orderUID)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, errors.WithMessage(err, "begin tx")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	const update = `
+		UPDATE orders SET status = $3, version = version + 1
+		WHERE order_uid = $1 AND version = $2 AND deleted_at IS NULL
+	`
+	res, err := tx.ExecContext(ctx, update, orderUID, u.Version, u.Order.Status)
+	if err != nil {
+		return nil, errors.WithMessage(err, "update order status")
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		return nil, tx.Rollback()
+	}
+
+	if len(u.Items) > 0 {
+		if err = updateItemStatusesTx(ctx, tx, orderUID, u.Items); err != nil {
+			return nil, err
+		}
+	}
+	if u.Order.From != u.Order.Status {
+		if err = insertStatusTx(ctx, tx, u.Order); err != nil {
+			return nil, err
+		}
+	}
+
+	orders, err := r.selectOrders(ctx, tx, `AND o.order_uid = $1`, orderUID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "select changed order")
+	}
+	if len(orders) == 0 {
+		err = errors.Errorf("changed order %s not found", orderUID)
+		return nil, err
+	}
+	o := orders[0]
+
+	if err = r.outbox.InsertTx(ctx, tx, o.OrderUID, models.OrderStatusChanged, o); err != nil {
+		return nil, errors.WithMessage(err, "insert order event")
+	}
+	if err = r.history.InsertTx(ctx, tx, o.OrderUID, models.OrderStatusChanged, o); err != nil {
+		return nil, errors.WithMessage(err, "insert order history")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WithMessage(err, "commit")
+	}
+	r.recent.mark(o.OrderUID, customerKey(o.CustomerId))
+	return &o, nil
+}
+
+// StatusHistory returns the status changes of an order, oldest first.
+func (r *OrderRepo) StatusHistory(ctx context.Context, orderUID string) (_ []models.StatusRecord, err error) {
+	ctx, span := startSpan(ctx, "OrderRepo.StatusHistory", orderUID)
+	defer func() { endSpan(span, err) }()
+
+	const sel = `
+		SELECT order_uid, COALESCE(from_status, '') AS from_status, status, reason,
+		       changed_at, recorded_at, source, actor
+		FROM order_status_history
+		WHERE order_uid = $1
+		ORDER BY id
+	`
+	var out []models.StatusRecord
+	err = r.read(ctx, orderUID, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &out, sel, orderUID)
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "select order status history")
+	}
+	return out, nil
+}
+
+// ItemStatusHistory returns the status changes of the items of an order,
+// oldest first.
+func (r *OrderRepo) ItemStatusHistory(ctx context.Context, orderUID string) (_ []models.ItemStatusRecord, err error) {
+	ctx, span := startSpan(ctx, "OrderRepo.ItemStatusHistory", orderUID)
+	defer func() { endSpan(span, err) }()
+
+	const sel = `
+		SELECT order_uid, chrt_id, rid, from_status, status, reason,
+		       changed_at, recorded_at, source, actor
+		FROM item_status_history
+		WHERE order_uid = $1
+		ORDER BY id
+	`
+	var out []models.ItemStatusRecord
+	err = r.read(ctx, orderUID, func(q sqlx.QueryerContext) error {
+		return sqlx.SelectContext(ctx, q, &out, sel, orderUID)
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "select item status history")
+	}
+	return out, nil
+}
+
+// updateItemStatusesTx moves the items of an order to the statuses in recs
+// and appends recs to their history, taking the source and actor from
+// models.AuditFrom.
+func updateItemStatusesTx(ctx context.Context, tx *sqlx.Tx, orderUID string, recs []models.ItemStatusRecord) error {
+	a := models.AuditFrom(ctx)
+	const update = `
+		UPDATE items SET fulfillment_status = $4, status_changed_at = $5
+		WHERE order_uid = $1 AND chrt_id = $2 AND rid = $3
+	`
+	const insert = `
+		INSERT INTO item_status_history (order_uid, chrt_id, rid, from_status, status, reason, changed_at, source, actor)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	for _, rec := range recs {
+		if _, err := tx.ExecContext(ctx, update, orderUID, rec.ChrtId, rec.Rid, rec.Status, rec.ChangedAt); err != nil {
+			return errors.WithMessage(err, "update item status")
+		}
+		if _, err := tx.ExecContext(ctx, insert, orderUID, rec.ChrtId, rec.Rid, rec.From, rec.Status,
+			rec.Reason, rec.ChangedAt, a.Source, a.Actor,
+		); err != nil {
+			return errors.WithMessage(err, "insert item status history")
+		}
+	}
+	return nil
+}
+
+// deriveStatusTx runs on every order write: if the status derived from the
+// items differs from o.Status, e.g. because the write added or removed items,
+// it moves o there and records the change in the status history.
+func deriveStatusTx(ctx context.Context, tx *sqlx.Tx, o *models.Order, at time.Time) error {
+	status, ok := models.DeriveStatus(o.Items)
+	if !ok || status == o.Status {
+		return nil
+	}
+	const update = `UPDATE orders SET status = $2 WHERE order_uid = $1`
+	if _, err := tx.ExecContext(ctx, update, o.OrderUID, status); err != nil {
+		return errors.WithMessage(err, "update derived order status")
+	}
+	rec := models.StatusRecord{OrderUID: o.OrderUID, From: o.Status, Status: status, Reason: models.ReasonItemsChanged, ChangedAt: at}
+	if err := insertStatusTx(ctx, tx, rec); err != nil {
+		return err
+	}
+	o.Status = status
+	return nil
+}
+
+// insertStatusTx appends rec to the status history, taking the source and
+// actor from models.AuditFrom.
+func insertStatusTx(ctx context.Context, tx *sqlx.Tx, rec models.StatusRecord) error {
+	a := models.AuditFrom(ctx)
+	const q = `
+		INSERT INTO order_status_history (order_uid, from_status, status, reason, changed_at, source, actor)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7)
+	`
+	if _, err := tx.ExecContext(ctx, q, rec.OrderUID, rec.From, rec.Status, rec.Reason, rec.ChangedAt, a.Source, a.Actor); err != nil {
+		return errors.WithMessage(err, "insert order status history (tx)")
+	}
+	return nil
+}
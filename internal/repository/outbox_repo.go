@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+	"wb/internal/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// relayLockKey is the advisory lock held by the active outbox relay so that
+// events are published by a single instance in id order.
+const relayLockKey = 7_310_001
+
+type OutboxRepo struct {
+	db *sqlx.DB
+}
+
+func NewOutboxRepo(db *sqlx.DB) *OutboxRepo {
+	return &OutboxRepo{db: db}
+}
+
+func (r *OutboxRepo) InsertTx(ctx context.Context, tx *sqlx.Tx, orderUID, eventType string, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return errors.WithMessage(err, "marshal event payload")
+	}
+
+	const q = `
+		INSERT INTO order_events (order_uid, event_type, payload)
+		VALUES ($1, $2, $3)
+	`
+	if _, err := tx.ExecContext(ctx, q, orderUID, eventType, b); err != nil {
+		return errors.WithMessage(err, "insert order event (tx)")
+	}
+	return nil
+}
+
+// Relay hands up to limit unpublished events to publish in id order and
+// marks them published once publish succeeds. It returns 0 without calling
+// publish when another relay holds the lock.
+func (r *OutboxRepo) Relay(ctx context.Context, limit int, publish func([]models.OrderEvent) error) (n int, err error) {
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return 0, errors.WithMessage(err, "begin tx")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var locked bool
+	if err = tx.GetContext(ctx, &locked, `SELECT pg_try_advisory_xact_lock($1)`, relayLockKey); err != nil {
+		return 0, errors.WithMessage(err, "acquire relay lock")
+	}
+	if !locked {
+		return 0, tx.Rollback()
+	}
+
+	const selEvents = `
+		SELECT id, order_uid, event_type, payload, created_at, published_at
+		FROM order_events
+		WHERE published_at IS NULL
+		ORDER BY id
+		LIMIT $1
+	`
+	var events []models.OrderEvent
+	if err = tx.SelectContext(ctx, &events, selEvents, limit); err != nil {
+		return 0, errors.WithMessage(err, "select unpublished events")
+	}
+	if len(events) == 0 {
+		return 0, tx.Rollback()
+	}
+
+	if err = publish(events); err != nil {
+		return 0, errors.WithMessage(err, "publish events")
+	}
+
+	ids := make([]int64, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	const markPublished = `UPDATE order_events SET published_at = now() WHERE id = ANY($1)`
+	if _, err = tx.ExecContext(ctx, markPublished, pq.Array(ids)); err != nil {
+		return 0, errors.WithMessage(err, "mark events published")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, errors.WithMessage(err, "commit")
+	}
+	return len(events), nil
+}
+
+func (r *OutboxRepo) DeletePublished(ctx context.Context, olderThan time.Duration) (int64, error) {
+	const q = `
+		DELETE FROM order_events
+		WHERE published_at IS NOT NULL AND published_at < now() - make_interval(secs => $1)
+	`
+	res, err := r.db.ExecContext(ctx, q, olderThan.Seconds())
+	if err != nil {
+		return 0, errors.WithMessage(err, "delete published events")
+	}
+	aff, _ := res.RowsAffected()
+	return aff, nil
+}
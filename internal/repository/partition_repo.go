@@ -0,0 +1,703 @@
+package repository
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"wb/internal/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	partitionPrefix  = "orders_"
+	defaultPartition = "orders_default"
+	archiveBatch     = 1000
+)
+
+// PartitionRepo maintains the monthly partitions of the orders table and
+// archives old ones to gzip'ed NDJSON files in dir.
+type PartitionRepo struct {
+	db    *sqlx.DB
+	items *ItemRepo
+	dir   string
+}
+
+func NewPartitionRepo(db *sqlx.DB, items *ItemRepo, dir string) *PartitionRepo {
+	return &PartitionRepo{
+		db:    db,
+		items: items,
+		dir:   dir,
+	}
+}
+
+// archiveRecord is one line of an archive file. Version and DeletedAt are
+// not part of the order JSON but are needed to re-attach it unchanged.
+type archiveRecord struct {
+	Order     models.Order `json:"order"`
+	Version   int64        `json:"version"`
+	DeletedAt *time.Time   `json:"deleted_at,omitempty"`
+}
+
+func partitionName(month time.Time) string {
+	return partitionPrefix + month.Format("2006_01")
+}
+
+func parsePartitionName(name string) (time.Time, bool) {
+	t, err := time.Parse(partitionPrefix+"2006_01", name)
+	return t, err == nil
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Partitions lists attached partitions, with an estimated row count, and
+// archived ones ordered by name.
+func (r *PartitionRepo) Partitions(ctx context.Context) (_ []models.OrderPartition, err error) {
+	ctx, span := tracer.Start(ctx, "PartitionRepo.Partitions", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { endSpan(span, err) }()
+
+	const selAttached = `
+		SELECT c.relname AS partition_name, GREATEST(c.reltuples, 0)::bigint AS orders
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'orders'::regclass
+	`
+	var parts []models.OrderPartition
+	if err = r.db.SelectContext(ctx, &parts, selAttached); err != nil {
+		return nil, errors.WithMessage(err, "select partitions")
+	}
+	for i := range parts {
+		if from, ok := parsePartitionName(parts[i].Name); ok {
+			parts[i].From, parts[i].To = from, from.AddDate(0, 1, 0)
+		}
+	}
+
+	const selArchived = `
+		SELECT partition_name, range_from, range_to, orders, path, archived_at
+		FROM order_archives
+	`
+	var archived []models.OrderPartition
+	if err = r.db.SelectContext(ctx, &archived, selArchived); err != nil {
+		return nil, errors.WithMessage(err, "select archives")
+	}
+	parts = append(parts, archived...)
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Name < parts[j].Name })
+	return parts, nil
+}
+
+// EnsurePartitions creates the partitions for months months starting with
+// the month of from, skipping existing and archived ones. It returns the
+// names of the created partitions.
+func (r *PartitionRepo) EnsurePartitions(ctx context.Context, from time.Time, months int) (_ []string, err error) {
+	ctx, span := tracer.Start(ctx, "PartitionRepo.EnsurePartitions",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("months", months)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	var created []string
+	month := monthStart(from)
+	for i := 0; i < months; i++ {
+		name := partitionName(month)
+		ok, err := r.createPartition(ctx, name, month)
+		if err != nil {
+			return created, errors.WithMessagef(err, "create partition %s", name)
+		}
+		if ok {
+			created = append(created, name)
+		}
+		month = month.AddDate(0, 1, 0)
+	}
+	return created, nil
+}
+
+func (r *PartitionRepo) createPartition(ctx context.Context, name string, month time.Time) (_ bool, err error) {
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return false, errors.WithMessage(err, "begin tx")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	if err = noTimeoutTx(ctx, tx); err != nil {
+		return false, err
+	}
+
+	const exists = `
+		SELECT to_regclass($1) IS NOT NULL
+		    OR EXISTS (SELECT 1 FROM order_archives WHERE partition_name = $1)
+	`
+	var found bool
+	if err = tx.GetContext(ctx, &found, exists, name); err != nil {
+		return false, errors.WithMessage(err, "check partition")
+	}
+	if found {
+		return false, tx.Rollback()
+	}
+
+	if err = attachPartitionTx(ctx, tx, name, month); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, errors.WithMessage(err, "commit")
+	}
+	return true, nil
+}
+
+// attachPartitionTx attaches table name, creating it if needed, as the
+// partition for the month. Rows of that month that landed in the default
+// partition are moved into it first, otherwise the attach would fail; while
+// they are moved wb.moving_orders keeps the orders_delete_children trigger
+// from deleting their deliveries, payments and items.
+func attachPartitionTx(ctx context.Context, tx *sqlx.Tx, name string, month time.Time) error {
+	ident := pq.QuoteIdentifier(name)
+	from, to := month, month.AddDate(0, 1, 0)
+
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+ident+` (LIKE orders INCLUDING DEFAULTS)`); err != nil {
+		return errors.WithMessage(err, "create partition table")
+	}
+
+	moveDefault := `
+		WITH moved AS (
+			DELETE FROM ` + defaultPartition + `
+			WHERE date_created >= $1 AND date_created < $2
+			RETURNING *
+		)
+		INSERT INTO ` + ident + ` SELECT * FROM moved
+	`
+	if _, err := tx.ExecContext(ctx, `SET LOCAL wb.moving_orders = 'on'`); err != nil {
+		return errors.WithMessage(err, "set wb.moving_orders")
+	}
+	if _, err := tx.ExecContext(ctx, moveDefault, from, to); err != nil {
+		return errors.WithMessage(err, "move rows from default partition")
+	}
+	if _, err := tx.ExecContext(ctx, `SET LOCAL wb.moving_orders = 'off'`); err != nil {
+		return errors.WithMessage(err, "reset wb.moving_orders")
+	}
+
+	attach := fmt.Sprintf(`ALTER TABLE orders ATTACH PARTITION %s FOR VALUES FROM (%s) TO (%s)`,
+		ident, pq.QuoteLiteral(from.Format(time.DateOnly)), pq.QuoteLiteral(to.Format(time.DateOnly)))
+	if _, err := tx.ExecContext(ctx, attach); err != nil {
+		return errors.WithMessage(err, "attach partition")
+	}
+	return nil
+}
+
+// Archive detaches a monthly partition, writes its orders with their
+// deliveries, payments and items to a compressed file and drops the rows.
+// Archived order uids stay indexed so that GetArchived can find them.
+//
+// The detach is committed on its own, so orders is only locked for a moment
+// and not for the whole export. If the export fails the partition is
+// attached again; should that fail too, the partition is left detached and
+// archiving it again picks it up.
+func (r *PartitionRepo) Archive(ctx context.Context, name string) (_ *models.OrderPartition, err error) {
+	ctx, span := tracer.Start(ctx, "PartitionRepo.Archive",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("partition", name)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	from, ok := parsePartitionName(name)
+	if !ok {
+		return nil, models.ErrPartitionNotFound
+	}
+	if err = r.detach(ctx, name); err != nil {
+		return nil, err
+	}
+
+	p, err := r.archiveDetached(ctx, name, from)
+	if err != nil {
+		if rerr := r.attach(context.WithoutCancel(ctx), name, from); rerr != nil {
+			return nil, errors.WithMessagef(err, "partition %s is left detached (attach it back: %v)", name, rerr)
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// detach detaches the partition table name from orders. A table that is
+// already detached but not archived, left by a failed Archive, is accepted
+// as is.
+func (r *PartitionRepo) detach(ctx context.Context, name string) (err error) {
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return errors.WithMessage(err, "begin tx")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	if err = noTimeoutTx(ctx, tx); err != nil {
+		return err
+	}
+
+	const state = `
+		SELECT to_regclass($1) IS NOT NULL AS found,
+		       EXISTS (
+		           SELECT 1 FROM pg_inherits
+		           WHERE inhparent = 'orders'::regclass AND inhrelid = to_regclass($1)
+		       ) AS attached
+	`
+	var part struct {
+		Found    bool `db:"found"`
+		Attached bool `db:"attached"`
+	}
+	if err = tx.GetContext(ctx, &part, state, name); err != nil {
+		return errors.WithMessage(err, "check partition")
+	}
+	if !part.Found {
+		err = models.ErrPartitionNotFound
+		return err
+	}
+	if !part.Attached {
+		return tx.Rollback()
+	}
+
+	if _, err = tx.ExecContext(ctx, `ALTER TABLE orders DETACH PARTITION `+pq.QuoteIdentifier(name)); err != nil {
+		return errors.WithMessage(err, "detach partition")
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.WithMessage(err, "commit detach")
+	}
+	return nil
+}
+
+// attach attaches the detached partition table name back to orders. Orders
+// written again while it was detached live in another partition now, so
+// their stale rows are dropped from it first.
+func (r *PartitionRepo) attach(ctx context.Context, name string, month time.Time) (err error) {
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return errors.WithMessage(err, "begin tx")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	if err = noTimeoutTx(ctx, tx); err != nil {
+		return err
+	}
+	stale := `DELETE FROM ` + pq.QuoteIdentifier(name) + ` t
+		WHERE EXISTS (SELECT 1 FROM orders o WHERE o.order_uid = t.order_uid)`
+	if _, err = tx.ExecContext(ctx, stale); err != nil {
+		return errors.WithMessage(err, "delete rewritten orders")
+	}
+	if err = attachPartitionTx(ctx, tx, name, month); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.WithMessage(err, "commit")
+	}
+	return nil
+}
+
+// archiveDetached exports the detached partition table name and drops it
+// with its child rows. Orders written again while the partition was
+// detached live in another partition now and keep their child rows.
+func (r *PartitionRepo) archiveDetached(ctx context.Context, name string, from time.Time) (_ *models.OrderPartition, err error) {
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, errors.WithMessage(err, "begin tx")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	if err = noTimeoutTx(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(r.dir, name+".ndjson.gz")
+	n, err := r.export(ctx, tx, name, path)
+	if err != nil {
+		return nil, errors.WithMessage(err, "export partition")
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(path)
+		}
+	}()
+
+	p := &models.OrderPartition{Name: name, From: from, To: from.AddDate(0, 1, 0), Orders: n, Path: path}
+	const insArchive = `
+		INSERT INTO order_archives (partition_name, range_from, range_to, path, orders)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING archived_at
+	`
+	if err = tx.GetContext(ctx, &p.ArchivedAt, insArchive, p.Name, p.From, p.To, p.Path, p.Orders); err != nil {
+		return nil, errors.WithMessage(err, "insert archive")
+	}
+
+	ident := pq.QuoteIdentifier(name)
+	for _, table := range []string{"deliveries", "payments", "items"} {
+		del := `DELETE FROM ` + table + ` c
+			WHERE c.order_uid IN (SELECT order_uid FROM ` + ident + `)
+			  AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_uid = c.order_uid)`
+		if _, err = tx.ExecContext(ctx, del); err != nil {
+			return nil, errors.WithMessagef(err, "delete archived %s", table)
+		}
+	}
+	if _, err = tx.ExecContext(ctx, `DROP TABLE `+ident); err != nil {
+		return nil, errors.WithMessage(err, "drop partition")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WithMessage(err, "commit")
+	}
+	return p, nil
+}
+
+// export writes every order of the detached partition table to path and
+// returns their number. Each batch is a separate gzip member whose offset is
+// recorded in archived_orders, so GetArchived only decompresses one batch.
+// The file only appears once it is complete.
+func (r *PartitionRepo) export(ctx context.Context, tx *sqlx.Tx, name, path string) (_ int64, err error) {
+	f, err := os.CreateTemp(r.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = f.Close()
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	out := &countingWriter{w: f}
+
+	sel := `SELECT ` + orderColumns + `, o.deleted_at
+		FROM ` + pq.QuoteIdentifier(name) + ` o
+		` + orderJoins + `
+		WHERE o.order_uid > $1
+		ORDER BY o.order_uid
+		LIMIT $2
+	`
+	var n int64
+	for after := ""; ; {
+		var rows []struct {
+			models.Order
+			DeletedAt *time.Time `db:"deleted_at"`
+		}
+		if err = sqlx.SelectContext(ctx, tx, &rows, sel, after, archiveBatch); err != nil {
+			return 0, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		uids := make([]string, len(rows))
+		for i := range rows {
+			uids[i] = rows[i].OrderUID
+		}
+		items, err := r.items.GetByOrderUIDs(ctx, tx, uids)
+		if err != nil {
+			return 0, err
+		}
+
+		offset := out.n
+		gz := gzip.NewWriter(out)
+		enc := json.NewEncoder(gz)
+		for _, row := range rows {
+			rec := archiveRecord{Order: row.Order, Version: row.Version, DeletedAt: row.DeletedAt}
+			rec.Order.Items = items[row.OrderUID]
+			if err = enc.Encode(rec); err != nil {
+				return 0, err
+			}
+		}
+		if err = gz.Close(); err != nil {
+			return 0, err
+		}
+		if _, err = tx.ExecContext(ctx, indexArchived, pq.Array(uids), name, offset); err != nil {
+			return 0, errors.WithMessage(err, "index archived orders")
+		}
+		n += int64(len(rows))
+		after = rows[len(rows)-1].OrderUID
+	}
+
+	if err = f.Sync(); err != nil {
+		return 0, err
+	}
+	if err = f.Close(); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(f.Name(), path)
+}
+
+const indexArchived = `
+	INSERT INTO archived_orders (order_uid, partition_name, archive_offset)
+	SELECT unnest($1::text[]), $2, $3
+	ON CONFLICT (order_uid) DO UPDATE SET
+		partition_name = EXCLUDED.partition_name,
+		archive_offset = EXCLUDED.archive_offset
+`
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readArchive calls fn for every record in the archive file until fn returns
+// false. With offset >= 0 only the gzip member starting there is read.
+func readArchive(path string, offset int64, fn func(archiveRecord) (bool, error)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if offset > 0 {
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err == io.EOF {
+		return nil // an empty partition has no members
+	}
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	if offset >= 0 {
+		gz.Multistream(false)
+	}
+
+	dec := json.NewDecoder(gz)
+	for {
+		var rec archiveRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		more, err := fn(rec)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// GetArchived looks an order up in the archive files. It returns nil if the
+// order is not archived, was deleted before archiving or exists again in the
+// live table.
+func (r *PartitionRepo) GetArchived(ctx context.Context, orderUID string) (_ *models.Order, err error) {
+	ctx, span := startSpan(ctx, "PartitionRepo.GetArchived", orderUID)
+	defer func() { endSpan(span, err) }()
+
+	const q = `
+		SELECT a.path, COALESCE(ao.archive_offset, -1) AS archive_offset
+		FROM archived_orders ao
+		JOIN order_archives a ON a.partition_name = ao.partition_name
+		WHERE ao.order_uid = $1
+		  AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_uid = $1)
+	`
+	var loc struct {
+		Path   string `db:"path"`
+		Offset int64  `db:"archive_offset"`
+	}
+	err = r.db.GetContext(ctx, &loc, q, orderUID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "select archive")
+	}
+
+	var found *models.Order
+	err = readArchive(loc.Path, loc.Offset, func(rec archiveRecord) (bool, error) {
+		if rec.Order.OrderUID != orderUID {
+			return true, ctx.Err()
+		}
+		if rec.DeletedAt == nil {
+			rec.Order.Version = rec.Version
+			found = &rec.Order
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read archive %s", loc.Path)
+	}
+	return found, nil
+}
+
+// Reattach loads an archived partition back from its file and attaches it.
+// Orders that exist in the live table again are skipped; the restored uids
+// are locked like any other write so that none can be created meanwhile.
+func (r *PartitionRepo) Reattach(ctx context.Context, name string) (_ *models.OrderPartition, err error) {
+	ctx, span := tracer.Start(ctx, "PartitionRepo.Reattach",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("partition", name)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return nil, errors.WithMessage(err, "begin tx")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	if err = noTimeoutTx(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	const selArchive = `
+		SELECT partition_name, range_from, range_to, orders, path, archived_at
+		FROM order_archives
+		WHERE partition_name = $1
+		FOR UPDATE
+	`
+	var p models.OrderPartition
+	err = tx.GetContext(ctx, &p, selArchive, name)
+	if err == sql.ErrNoRows {
+		err = models.ErrPartitionNotFound
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "select archive")
+	}
+
+	stage := append(stagingTables[:len(stagingTables):len(stagingTables)],
+		`CREATE TEMP TABLE stage_archive (order_uid TEXT PRIMARY KEY, version BIGINT NOT NULL, deleted_at TIMESTAMPTZ, status TEXT) ON COMMIT DROP`)
+	for _, q := range stage {
+		if _, err = tx.ExecContext(ctx, q); err != nil {
+			return nil, errors.WithMessage(err, "create staging table")
+		}
+	}
+
+	batch := make([]archiveRecord, 0, archiveBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		orders := make([]models.Order, len(batch))
+		rows := make([][]any, len(batch))
+		for i, rec := range batch {
+			orders[i] = rec.Order
+			rows[i] = []any{rec.Order.OrderUID, rec.Version, rec.DeletedAt, string(rec.Order.Status)}
+		}
+		batch = batch[:0]
+		if err := copyOrders(ctx, tx, orders); err != nil {
+			return err
+		}
+		return copyIn(ctx, tx, "stage_archive", []string{"order_uid", "version", "deleted_at", "status"}, rows)
+	}
+	err = readArchive(p.Path, -1, func(rec archiveRecord) (bool, error) {
+		batch = append(batch, rec)
+		if len(batch) < archiveBatch {
+			return true, nil
+		}
+		return true, flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "load archive %s", p.Path)
+	}
+
+	ident := pq.QuoteIdentifier(name)
+	stmts := []struct {
+		name string
+		q    string
+	}{
+		{"lock orders", lockStagedOrders},
+		{"create partition table", `CREATE TABLE ` + ident + ` (LIKE orders INCLUDING DEFAULTS)`},
+		{"restore orders", `
+			INSERT INTO ` + ident + ` (
+				order_uid, track_number, entry, locale, internal_signature, customer_id,
+				delivery_service, shardkey, sm_id, date_created, oof_shard, version, deleted_at, status
+			)
+			SELECT s.order_uid, s.track_number, s.entry, s.locale, s.internal_signature, s.customer_id,
+			       s.delivery_service, s.shardkey, s.sm_id, s.date_created, s.oof_shard, a.version, a.deleted_at,
+			       COALESCE(NULLIF(a.status, ''), '` + string(models.StatusCreated) + `')
+			FROM stage_orders s
+			JOIN stage_archive a ON a.order_uid = s.order_uid
+			WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_uid = s.order_uid)
+		`},
+		{"restore deliveries", `
+			INSERT INTO deliveries (order_uid, name, phone, zip, city, address, region, email)
+			SELECT order_uid, name, phone, zip, city, address, region, email
+			FROM stage_deliveries
+			WHERE order_uid IN (SELECT order_uid FROM ` + ident + `)
+			ON CONFLICT (order_uid) DO NOTHING
+		`},
+		{"restore payments", `
+			INSERT INTO payments (
+				order_uid, transaction, request_id, currency, provider, amount,
+				payment_dt, bank, delivery_cost, goods_total, custom_fee,
+				reporting_amount, reporting_currency
+			)
+			SELECT order_uid, transaction, request_id, currency, provider, amount,
+			       payment_dt, bank, delivery_cost, goods_total, custom_fee,
+			       reporting_amount, reporting_currency
+			FROM stage_payments
+			WHERE order_uid IN (SELECT order_uid FROM ` + ident + `)
+			ON CONFLICT (order_uid) DO NOTHING
+		`},
+		{"restore items", `
+			INSERT INTO items (
+				order_uid, chrt_id, track_number, price, rid, name, sale, size,
+				total_price, nm_id, brand, status, fulfillment_status, status_changed_at
+			)
+			SELECT order_uid, chrt_id, track_number, price, rid, name, sale, size,
+			       total_price, nm_id, brand, status, fulfillment_status, status_changed_at
+			FROM stage_items
+			WHERE order_uid IN (SELECT order_uid FROM ` + ident + `)
+			ON CONFLICT (order_uid, chrt_id, rid) DO NOTHING
+		`},
+	}
+	for _, s := range stmts {
+		if _, err = tx.ExecContext(ctx, s.q); err != nil {
+			return nil, errors.WithMessage(err, s.name)
+		}
+	}
+
+	if err = tx.GetContext(ctx, &p.Orders, `SELECT COUNT(*) FROM `+ident); err != nil {
+		return nil, errors.WithMessage(err, "count restored orders")
+	}
+	if err = attachPartitionTx(ctx, tx, name, p.From); err != nil {
+		return nil, err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM order_archives WHERE partition_name = $1`, name); err != nil {
+		return nil, errors.WithMessage(err, "delete archive")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WithMessage(err, "commit")
+	}
+	_ = os.Remove(p.Path)
+
+	p.Path, p.ArchivedAt = "", nil
+	return &p, nil
+}
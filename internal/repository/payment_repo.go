@@ -23,21 +23,22 @@ type Payment struct {
 	RequestId    string `json:"request_id" db:"request_id"`
 	Currency     string `json:"currency" db:"currency"`
 	Provider     string `json:"provider" db:"provider"`
-	Amount       int    `json:"amount" db:"amount"`
+	Amount       int64  `json:"amount" db:"amount"`
 	PaymentDt    int64  `json:"payment_dt" db:"payment_dt"`
 	Bank         string `json:"bank" db:"bank"`
-	DeliveryCost int    `json:"delivery_cost" db:"delivery_cost"`
-	GoodsTotal   int    `json:"goods_total" db:"goods_total"`
-	CustomFee    int    `json:"custom_fee" db:"custom_fee"`
+	DeliveryCost int64  `json:"delivery_cost" db:"delivery_cost"`
+	GoodsTotal   int64  `json:"goods_total" db:"goods_total"`
+	CustomFee    int64  `json:"custom_fee" db:"custom_fee"`
 }
 
 func (r *PaymentRepo) UpsertTx(ctx context.Context, tx *sqlx.Tx, p models.Payment) (string, error) {
 	const q = `
 		INSERT INTO payments (
 			order_uid, transaction, request_id, currency, provider, amount,
-			payment_dt, bank, delivery_cost, goods_total, custom_fee
+			payment_dt, bank, delivery_cost, goods_total, custom_fee,
+			reporting_amount, reporting_currency
 		) VALUES (
-			$1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11
+			$1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13
 		)
 		ON CONFLICT (order_uid) DO UPDATE SET
 			transaction   = EXCLUDED.transaction,
@@ -49,13 +50,17 @@ func (r *PaymentRepo) UpsertTx(ctx context.Context, tx *sqlx.Tx, p models.Paymen
 			bank          = EXCLUDED.bank,
 			delivery_cost = EXCLUDED.delivery_cost,
 			goods_total   = EXCLUDED.goods_total,
-			custom_fee    = EXCLUDED.custom_fee
+			custom_fee    = EXCLUDED.custom_fee,
+			reporting_amount   = EXCLUDED.reporting_amount,
+			reporting_currency = EXCLUDED.reporting_currency
 		RETURNING order_uid
 	`
+	reportingAmount, reportingCurrency := reportingValues(p)
 	var uid string
 	if err := tx.QueryRowxContext(ctx, q,
 		p.OrderUID, p.Transaction, p.RequestId, p.Currency, p.Provider, p.Amount,
 		p.PaymentDt, p.Bank, p.DeliveryCost, p.GoodsTotal, p.CustomFee,
+		reportingAmount, reportingCurrency,
 	).Scan(&uid); err != nil {
 		return "", errors.WithMessage(err, "upsert payment (tx)")
 	}
@@ -65,7 +70,9 @@ func (r *PaymentRepo) UpsertTx(ctx context.Context, tx *sqlx.Tx, p models.Paymen
 func (r *PaymentRepo) Get(ctx context.Context, orderUID string) (*models.Payment, error) {
 	const q = `
 		SELECT order_uid, transaction, request_id, currency, provider, amount,
-		       payment_dt, bank, delivery_cost, goods_total, custom_fee
+		       payment_dt, bank, delivery_cost, goods_total, custom_fee,
+		       COALESCE(reporting_amount, 0) AS reporting_amount,
+		       COALESCE(reporting_currency, '') AS reporting_currency
 		FROM payments
 		WHERE order_uid = $1
 	`
@@ -76,5 +83,15 @@ func (r *PaymentRepo) Get(ctx context.Context, orderUID string) (*models.Payment
 		}
 		return nil, errors.WithMessage(err, "select payment by order_uid")
 	}
+	out.FillCurrency()
 	return &out, nil
 }
+
+// reportingValues returns the reporting amount columns of p, NULL while the
+// payment is not converted.
+func reportingValues(p models.Payment) (amount, currency any) {
+	if m, ok := p.Reporting(); ok {
+		return m.Amount, m.Currency
+	}
+	return nil, nil
+}
@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"os"
+	"testing"
+	"wb/pkg/postgres"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// testDB connects to PG_DSN and migrates it, skipping tb when PG_DSN is not
+// set. Point it at a scratch database: tests and benchmarks write orders.
+func testDB(tb testing.TB) *sqlx.DB {
+	tb.Helper()
+	if os.Getenv("PG_DSN") == "" {
+		tb.Skip("PG_DSN is not set")
+	}
+	db, err := postgres.InitDB(zap.NewNop().Sugar())
+	if err != nil {
+		tb.Fatalf("init db: %v", err)
+	}
+	tb.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newTestOrderRepo(db *sqlx.DB) *OrderRepo {
+	return NewOrderRepo(db, NewDeliveryRepo(db), NewPaymentRepo(db), NewItemRepo(db),
+		NewOutboxRepo(db), NewHistoryRepo(db), nil, nil)
+}
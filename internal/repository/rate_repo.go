@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"wb/internal/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+type RateRepo struct {
+	db *sqlx.DB
+}
+
+func NewRateRepo(db *sqlx.DB) *RateRepo {
+	return &RateRepo{db: db}
+}
+
+// Rates returns every rate of the exchange_rates table.
+func (r *RateRepo) Rates(ctx context.Context) ([]models.ExchangeRate, error) {
+	const q = `
+		SELECT currency, quote, effective_from, rate
+		FROM exchange_rates
+		ORDER BY currency, quote, effective_from
+	`
+	var rates []models.ExchangeRate
+	if err := r.db.SelectContext(ctx, &rates, q); err != nil {
+		return nil, errors.WithMessage(err, "select exchange rates")
+	}
+	return rates, nil
+}
+
+// RateFile reads rates from a CSV file with the header
+// currency,quote,effective_from,rate; effective_from is a date (2006-01-02)
+// or an RFC3339 timestamp. The file is re-read on every call so it can be
+// edited while the service runs.
+type RateFile struct {
+	path string
+}
+
+func NewRateFile(path string) *RateFile {
+	return &RateFile{path: path}
+}
+
+func (f *RateFile) Rates(_ context.Context) ([]models.ExchangeRate, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, errors.WithMessage(err, "open rates file")
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.Comment = '#'
+	r.FieldsPerRecord = 4
+	r.TrimLeadingSpace = true
+
+	var rates []models.ExchangeRate
+	for line := 1; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, "read rates file")
+		}
+		if line == 1 && strings.EqualFold(rec[0], "currency") {
+			continue
+		}
+
+		rate, err := parseRate(rec)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "%s: line %d", f.path, line)
+		}
+		rates = append(rates, rate)
+	}
+}
+
+func parseRate(rec []string) (models.ExchangeRate, error) {
+	rate := models.ExchangeRate{
+		Currency: strings.ToUpper(rec[0]),
+		Quote:    strings.ToUpper(rec[1]),
+	}
+	if !models.ValidCurrency(rate.Currency) || !models.ValidCurrency(rate.Quote) {
+		return rate, errors.Errorf("bad currency pair %s/%s", rec[0], rec[1])
+	}
+
+	var err error
+	if rate.EffectiveFrom, err = time.Parse(time.DateOnly, rec[2]); err != nil {
+		if rate.EffectiveFrom, err = time.Parse(time.RFC3339, rec[2]); err != nil {
+			return rate, errors.Errorf("bad effective_from %q", rec[2])
+		}
+	}
+	if rate.Rate, err = strconv.ParseFloat(rec[3], 64); err != nil || rate.Rate <= 0 {
+		return rate, errors.Errorf("bad rate %q", rec[3])
+	}
+	return rate, nil
+}
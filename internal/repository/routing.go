@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+	"wb/internal/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// recentWrites remembers keys written within window so that reads of them
+// are served by the primary until replicas have caught up. Expired keys are
+// dropped at most once per window, so a write does not scan every key.
+type recentWrites struct {
+	mu     sync.Mutex
+	window time.Duration
+	at     map[string]time.Time
+	swept  time.Time
+}
+
+func newRecentWrites(window time.Duration) *recentWrites {
+	return &recentWrites{window: window, at: make(map[string]time.Time)}
+}
+
+func (w *recentWrites) mark(keys ...string) {
+	if w.window <= 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(w.swept) > w.window {
+		for k, t := range w.at {
+			if now.Sub(t) > w.window {
+				delete(w.at, k)
+			}
+		}
+		w.swept = now
+	}
+	for _, k := range keys {
+		w.at[k] = now
+	}
+}
+
+func (w *recentWrites) has(key string) bool {
+	if w.window <= 0 || key == "" {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	t, ok := w.at[key]
+	return ok && time.Since(t) <= w.window
+}
+
+func customerKey(customerID string) string {
+	return "customer:" + customerID
+}
+
+// read runs fn against a replica unless ctx or a recent write of key pins
+// the read to the primary. A failed replica read is retried on the primary
+// and the replica is taken out of rotation.
+func (r *OrderRepo) read(ctx context.Context, key string, fn func(q sqlx.QueryerContext) error) error {
+	if r.replicas == nil || models.PrimaryFrom(ctx) || r.recent.has(key) {
+		return fn(r.db)
+	}
+
+	db := r.replicas.Reader()
+	err := fn(db)
+	if err != nil && db != r.db && ctx.Err() == nil {
+		r.replicas.MarkFailed(db)
+		err = fn(r.db)
+	}
+	return err
+}
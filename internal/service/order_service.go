@@ -2,24 +2,44 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"go.uber.org/zap"
+	"time"
 	"wb/internal/models"
 )
 
 type OrderRepo interface {
 	Get(ctx context.Context, orderUID string) (*models.Order, error)
-	Upsert(ctx context.Context, order models.Order) (string, error)
-	Delete(ctx context.Context, orderUID string) (int64, error)
+	Upsert(ctx context.Context, order *models.Order, expectedVersion int64) (int64, error)
+	BulkUpsert(ctx context.Context, orders []models.Order) (int, error)
+	Delete(ctx context.Context, orderUID string) (customerID string, ok bool, err error)
+	Restore(ctx context.Context, orderUID string) (*models.Order, error)
+	Purge(ctx context.Context, olderThan time.Duration) (int64, error)
+	List(ctx context.Context, f models.OrderFilter) (*models.OrderPage, error)
+	Lookup(ctx context.Context, key models.LookupKey, value string) ([]models.Order, error)
+	Search(ctx context.Context, q string, limit int) ([]models.SearchHit, error)
+	CustomerHistory(ctx context.Context, customerID string) (*models.CustomerHistory, error)
+	History(ctx context.Context, orderUID string) ([]models.OrderVersion, error)
+	GetAsOf(ctx context.Context, orderUID string, t time.Time) (*models.Order, error)
+	Fulfillment(ctx context.Context, orderUID string) (*models.Fulfillment, error)
+	SetStatus(ctx context.Context, u models.StatusUpdate) (*models.Order, error)
+	StatusHistory(ctx context.Context, orderUID string) ([]models.StatusRecord, error)
+	ItemStatusHistory(ctx context.Context, orderUID string) ([]models.ItemStatusRecord, error)
 }
 
 type OrderService struct {
 	orderRepo OrderRepo
+	rates     *Converter
 	logger    *zap.SugaredLogger
 }
 
-func NewOrderService(logger *zap.SugaredLogger, orderRepo OrderRepo) *OrderService {
+// NewOrderService creates the service; with rates set, written orders get
+// their reporting amount filled in.
+func NewOrderService(logger *zap.SugaredLogger, orderRepo OrderRepo, rates *Converter) *OrderService {
 	return &OrderService{
 		orderRepo: orderRepo,
+		rates:     rates,
 		logger:    logger,
 	}
 }
@@ -28,10 +48,218 @@ func (s *OrderService) GetOrder(ctx context.Context, orderUID string) (*models.O
 	return s.orderRepo.Get(ctx, orderUID)
 }
 
-func (s *OrderService) UpsertOrder(ctx context.Context, order models.Order) (orderUid string, err error) {
-	orderUid, err = s.orderRepo.Upsert(ctx, order)
+// UpsertOrder writes order, setting its reporting amount first.
+func (s *OrderService) UpsertOrder(ctx context.Context, order *models.Order) (version int64, err error) {
+	s.convert(order)
+	version, err = s.orderRepo.Upsert(ctx, order, 0)
 	if err != nil {
-		return orderUid, err
+		return 0, err
 	}
-	return orderUid, nil
+	return version, nil
+}
+
+// UpdateOrder writes order only if its stored version still equals
+// expectedVersion (0 skips the check, models.AnyVersion only requires the
+// order to exist). A lost race is reported as *models.ConflictError so
+// callers can tell it apart from failures. Like UpsertOrder it sets the
+// reporting amount of order.
+func (s *OrderService) UpdateOrder(ctx context.Context, order *models.Order, expectedVersion int64) (int64, error) {
+	s.convert(order)
+	version, err := s.orderRepo.Upsert(ctx, order, expectedVersion)
+	var conflict *models.ConflictError
+	if errors.As(err, &conflict) {
+		s.logger.Infow("order update conflict",
+			"order_uid", order.OrderUID, "expected", conflict.Expected, "actual", conflict.Actual)
+		return 0, conflict
+	}
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// UpsertOrders writes orders in bulk, setting their reporting amounts in place.
+func (s *OrderService) UpsertOrders(ctx context.Context, orders []models.Order) (int, error) {
+	for i := range orders {
+		s.convert(&orders[i])
+	}
+	return s.orderRepo.BulkUpsert(ctx, orders)
+}
+
+// convert sets the reporting amount of order, replacing whatever the writer
+// sent. Orders paid on a date without a known rate are stored unconverted
+// and picked up by Converter.Backfill.
+func (s *OrderService) convert(order *models.Order) {
+	if s.rates == nil {
+		order.Payment.ReportingAmount, order.Payment.ReportingCurrency = models.Money{}, ""
+		return
+	}
+	if !s.rates.Apply(&order.Payment) {
+		s.logger.Debugw("no exchange rate for order",
+			"order_uid", order.OrderUID, "currency", order.Payment.Currency, "payment_dt", order.Payment.PaymentDt)
+	}
+}
+
+func (s *OrderService) ListOrders(ctx context.Context, f models.OrderFilter) (*models.OrderPage, error) {
+	return s.orderRepo.List(ctx, f)
+}
+
+func (s *OrderService) LookupOrders(ctx context.Context, key models.LookupKey, value string) ([]models.Order, error) {
+	return s.orderRepo.Lookup(ctx, key, value)
+}
+
+func (s *OrderService) SearchOrders(ctx context.Context, q string, limit int) ([]models.SearchHit, error) {
+	return s.orderRepo.Search(ctx, q, limit)
+}
+
+func (s *OrderService) GetCustomerHistory(ctx context.Context, customerID string) (*models.CustomerHistory, error) {
+	return s.orderRepo.CustomerHistory(ctx, customerID)
+}
+
+func (s *OrderService) GetOrderHistory(ctx context.Context, orderUID string) ([]models.OrderVersion, error) {
+	return s.orderRepo.History(ctx, orderUID)
+}
+
+func (s *OrderService) GetOrderAsOf(ctx context.Context, orderUID string, t time.Time) (*models.Order, error) {
+	return s.orderRepo.GetAsOf(ctx, orderUID, t)
+}
+
+// DeleteOrder soft-deletes an order and returns the customer it belonged to;
+// ok is false if there was no such order.
+func (s *OrderService) DeleteOrder(ctx context.Context, orderUID string) (customerID string, ok bool, err error) {
+	return s.orderRepo.Delete(ctx, orderUID)
+}
+
+func (s *OrderService) RestoreOrder(ctx context.Context, orderUID string) (*models.Order, error) {
+	return s.orderRepo.Restore(ctx, orderUID)
+}
+
+func (s *OrderService) PurgeDeletedOrders(ctx context.Context, olderThan time.Duration) (int64, error) {
+	return s.orderRepo.Purge(ctx, olderThan)
+}
+
+// statusAttempts bounds how often ChangeStatus retries when the order is
+// changed concurrently between reading and updating it.
+const statusAttempts = 3
+
+// ChangeStatus moves an order, or the items of it listed in change.Items,
+// to change.Status if the lifecycle allows it and returns the order, or nil
+// if there is no such live order. A whole-order change moves every item that
+// can make it; the order status is then derived from its items. Asking for
+// the current status again is a no-op, so redelivered events are harmless;
+// a forbidden change is reported as *models.StatusTransitionError.
+func (s *OrderService) ChangeStatus(ctx context.Context, change models.StatusChange) (*models.Order, error) {
+	if !change.Status.Valid() {
+		return nil, fmt.Errorf("%w: %q", models.ErrInvalidStatus, change.Status)
+	}
+	if change.ChangedAt.IsZero() {
+		change.ChangedAt = time.Now()
+	}
+
+	for attempt := 0; attempt < statusAttempts; attempt++ {
+		f, err := s.orderRepo.Fulfillment(ctx, change.OrderUID)
+		if err != nil || f == nil {
+			return nil, err
+		}
+		u, err := planStatus(f, change)
+		if err != nil {
+			return nil, err
+		}
+		if u == nil {
+			// Fulfillment read the primary; a replica may not have caught up yet.
+			return s.orderRepo.Get(models.WithPrimary(ctx), change.OrderUID)
+		}
+
+		order, err := s.orderRepo.SetStatus(ctx, *u)
+		if err != nil {
+			return nil, err
+		}
+		if order != nil {
+			s.logger.Infow("order status changed", "order_uid", change.OrderUID,
+				"from", u.Order.From, "to", u.Order.Status, "items", len(u.Items))
+			return order, nil
+		}
+	}
+	return nil, fmt.Errorf("order %s: status keeps changing concurrently", change.OrderUID)
+}
+
+// planStatus checks change against the current statuses in f and returns
+// the update to store, or nil if nothing would change.
+func planStatus(f *models.Fulfillment, change models.StatusChange) (*models.StatusUpdate, error) {
+	items := append([]models.Item(nil), f.Items...)
+	record := func(from, to models.OrderStatus) models.StatusRecord {
+		return models.StatusRecord{
+			OrderUID:  f.OrderUID,
+			From:      from,
+			Status:    to,
+			Reason:    change.Reason,
+			ChangedAt: change.ChangedAt,
+		}
+	}
+
+	u := &models.StatusUpdate{Version: f.Version}
+	move := func(i int) {
+		it := &items[i]
+		u.Items = append(u.Items, models.ItemStatusRecord{
+			ItemRef:      it.Ref(),
+			StatusRecord: record(it.FulfillmentStatus, change.Status),
+		})
+		it.FulfillmentStatus = change.Status
+	}
+
+	if len(change.Items) == 0 {
+		if f.Status == change.Status {
+			return nil, nil
+		}
+		if !f.Status.CanBecome(change.Status) {
+			return nil, &models.StatusTransitionError{OrderUID: f.OrderUID, From: f.Status, To: change.Status}
+		}
+		for i, it := range items {
+			if it.FulfillmentStatus.CanBecome(change.Status) {
+				move(i)
+			}
+		}
+	} else {
+		pos := make(map[models.ItemRef]int, len(items))
+		for i, it := range items {
+			pos[it.Ref()] = i
+		}
+		for _, ref := range change.Items {
+			i, ok := pos[ref]
+			if !ok {
+				return nil, fmt.Errorf("%w: order %s item %s", models.ErrItemNotFound, f.OrderUID, ref)
+			}
+			from := items[i].FulfillmentStatus
+			if from == change.Status {
+				continue
+			}
+			if !from.CanBecome(change.Status) {
+				return nil, &models.StatusTransitionError{OrderUID: f.OrderUID, Item: &ref, From: from, To: change.Status}
+			}
+			move(i)
+		}
+	}
+
+	status, ok := models.DeriveStatus(items)
+	if !ok {
+		status = change.Status
+	}
+	// Items that cannot move hold the order back; a whole-order change must
+	// not leave it at a status nobody asked for, even a reachable one.
+	if len(change.Items) == 0 && status != change.Status {
+		return nil, &models.StatusTransitionError{OrderUID: f.OrderUID, From: f.Status, To: change.Status, Derived: status}
+	}
+	if len(u.Items) == 0 && status == f.Status {
+		return nil, nil
+	}
+	u.Order = record(f.Status, status)
+	return u, nil
+}
+
+func (s *OrderService) GetStatusHistory(ctx context.Context, orderUID string) ([]models.StatusRecord, error) {
+	return s.orderRepo.StatusHistory(ctx, orderUID)
+}
+
+func (s *OrderService) GetItemStatusHistory(ctx context.Context, orderUID string) ([]models.ItemStatusRecord, error) {
+	return s.orderRepo.ItemStatusHistory(ctx, orderUID)
 }
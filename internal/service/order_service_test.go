@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"wb/internal/models"
+)
+
+func TestPlanStatus(t *testing.T) {
+	item := func(rid string, s models.OrderStatus) models.Item {
+		return models.Item{ChrtId: 1, Rid: rid, FulfillmentStatus: s}
+	}
+	tests := []struct {
+		name    string
+		status  models.OrderStatus
+		items   []models.Item
+		to      models.OrderStatus
+		want    models.OrderStatus // "" when nothing is stored
+		moved   int
+		derived models.OrderStatus // set when the change is rejected
+	}{
+		{
+			name:   "all items move",
+			status: models.StatusAssembling,
+			items:  []models.Item{item("a", models.StatusAssembling), item("b", models.StatusAssembling)},
+			to:     models.StatusCancelled,
+			want:   models.StatusCancelled,
+			moved:  2,
+		},
+		{
+			name:   "cancelled items stay",
+			status: models.StatusAssembling,
+			items:  []models.Item{item("a", models.StatusCancelled), item("b", models.StatusAssembling)},
+			to:     models.StatusShipped,
+			want:   models.StatusShipped,
+			moved:  1,
+		},
+		{
+			name:   "already there",
+			status: models.StatusPaid,
+			items:  []models.Item{item("a", models.StatusPaid)},
+			to:     models.StatusPaid,
+		},
+		{
+			name:    "shipped item turns a cancel into shipping",
+			status:  models.StatusAssembling,
+			items:   []models.Item{item("a", models.StatusShipped), item("b", models.StatusAssembling)},
+			to:      models.StatusCancelled,
+			derived: models.StatusShipped,
+		},
+		{
+			name:    "unreachable derived status",
+			status:  models.StatusPaid,
+			items:   []models.Item{item("a", models.StatusShipped), item("b", models.StatusPaid)},
+			to:      models.StatusCancelled,
+			derived: models.StatusShipped,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &models.Fulfillment{OrderUID: "o1", Status: tc.status, Version: 3, Items: tc.items}
+			change := models.StatusChange{OrderUID: "o1", Status: tc.to, ChangedAt: time.Now()}
+			u, err := planStatus(f, change)
+
+			if tc.derived != "" {
+				var te *models.StatusTransitionError
+				if !errors.As(err, &te) || te.Derived != tc.derived || te.From != tc.status || te.To != tc.to {
+					t.Fatalf("err = %v, want a transition error deriving %s", err, tc.derived)
+				}
+				if u != nil {
+					t.Fatalf("rejected change planned an update %+v", u)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.want == "" {
+				if u != nil {
+					t.Fatalf("no-op change planned an update %+v", u)
+				}
+				return
+			}
+			if u == nil || u.Order.From != tc.status || u.Order.Status != tc.want || u.Version != 3 || len(u.Items) != tc.moved {
+				t.Fatalf("update = %+v, want %s -> %s at version 3 moving %d items", u, tc.status, tc.want, tc.moved)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"time"
+	"wb/internal/models"
+
+	"go.uber.org/zap"
+)
+
+type PartitionStore interface {
+	Partitions(ctx context.Context) ([]models.OrderPartition, error)
+	EnsurePartitions(ctx context.Context, from time.Time, months int) ([]string, error)
+	Archive(ctx context.Context, name string) (*models.OrderPartition, error)
+	Reattach(ctx context.Context, name string) (*models.OrderPartition, error)
+}
+
+// Partitioner keeps monthly order partitions created ahead months in
+// advance and archives partitions older than archiveAfter months.
+type Partitioner struct {
+	store        PartitionStore
+	ahead        int
+	archiveAfter int
+	logger       *zap.SugaredLogger
+}
+
+func NewPartitioner(logger *zap.SugaredLogger, store PartitionStore, ahead, archiveAfter int) *Partitioner {
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
+	return &Partitioner{
+		store:        store,
+		ahead:        ahead,
+		archiveAfter: archiveAfter,
+		logger:       logger,
+	}
+}
+
+// Run maintains partitions right away and then every interval.
+func (p *Partitioner) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	p.logger.Infow("order partitioner started", "interval", interval, "ahead", p.ahead, "archive_after", p.archiveAfter)
+
+	for {
+		if err := p.Maintain(ctx, time.Now()); err != nil {
+			p.logger.Errorw("partition maintenance failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			p.logger.Infow("order partitioner stopped")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Maintain creates the partitions for the current and the next ahead
+// months and, if archiving is enabled, archives partitions that ended more
+// than archiveAfter months before the current month.
+func (p *Partitioner) Maintain(ctx context.Context, now time.Time) error {
+	now = now.UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	created, err := p.store.EnsurePartitions(ctx, month, p.ahead+1)
+	if len(created) > 0 {
+		p.logger.Infow("order partitions created", "partitions", created)
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.archiveAfter <= 0 {
+		return nil
+	}
+	cutoff := month.AddDate(0, -p.archiveAfter, 0)
+
+	parts, err := p.store.Partitions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if part.ArchivedAt != nil || part.To.IsZero() || part.To.After(cutoff) {
+			continue
+		}
+		archived, err := p.store.Archive(ctx, part.Name)
+		if err != nil {
+			return err
+		}
+		p.logger.Infow("order partition archived", "partition", archived.Name, "orders", archived.Orders, "path", archived.Path)
+	}
+	return nil
+}
+
+func (p *Partitioner) Partitions(ctx context.Context) ([]models.OrderPartition, error) {
+	return p.store.Partitions(ctx)
+}
+
+func (p *Partitioner) ArchivePartition(ctx context.Context, name string) (*models.OrderPartition, error) {
+	return p.store.Archive(ctx, name)
+}
+
+func (p *Partitioner) ReattachPartition(ctx context.Context, name string) (*models.OrderPartition, error) {
+	return p.store.Reattach(ctx, name)
+}
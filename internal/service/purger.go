@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type Purger struct {
+	service   *OrderService
+	retention time.Duration
+	logger    *zap.SugaredLogger
+}
+
+func NewPurger(logger *zap.SugaredLogger, service *OrderService, retention time.Duration) *Purger {
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
+	return &Purger{
+		service:   service,
+		retention: retention,
+		logger:    logger,
+	}
+}
+
+// Run hard-deletes orders soft-deleted more than retention ago, every interval.
+func (p *Purger) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	p.logger.Infow("order purger started", "interval", interval, "retention", p.retention)
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.logger.Infow("order purger stopped")
+			return nil
+		case <-ticker.C:
+			n, err := p.service.PurgeDeletedOrders(ctx, p.retention)
+			if err != nil {
+				p.logger.Errorw("purge deleted orders failed", "err", err)
+				continue
+			}
+			if n > 0 {
+				p.logger.Infow("deleted orders purged", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"maps"
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"wb/internal/models"
+
+	"go.uber.org/zap"
+)
+
+type RateSource interface {
+	Rates(ctx context.Context) ([]models.ExchangeRate, error)
+}
+
+type ReportingStore interface {
+	UnconvertedPayments(ctx context.Context, after string, limit int) ([]models.Payment, error)
+	PaymentsSince(ctx context.Context, currency string, since time.Time, after string, limit int) ([]models.Payment, error)
+	SetReporting(ctx context.Context, payments []models.Payment) (map[string]string, error)
+}
+
+// ReportingCache holds orders and customer histories, which show reporting
+// amounts.
+type ReportingCache interface {
+	Remove(orderUID string)
+	InvalidateCustomer(customerID string)
+}
+
+const backfillBatch = 500
+
+// Converter converts payment amounts into the reporting currency using the
+// rate in effect at payment_dt. Rates quoted in the reporting currency are
+// used as is, rates quoted the other way round are inverted.
+type Converter struct {
+	source   RateSource
+	store    ReportingStore
+	cache    ReportingCache
+	currency string
+	logger   *zap.SugaredLogger
+
+	mu     sync.RWMutex
+	rates  map[string][]models.ExchangeRate // by currency, oldest first
+	loaded bool
+	// stale maps a currency to the payment time from which its stored
+	// reporting amounts were converted with other rates; allCurrencies
+	// stands for every payment.
+	stale map[string]time.Time
+}
+
+const allCurrencies = ""
+
+func NewConverter(logger *zap.SugaredLogger, source RateSource, store ReportingStore, cache ReportingCache, currency string) *Converter {
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
+	return &Converter{
+		source:   source,
+		store:    store,
+		cache:    cache,
+		currency: currency,
+		logger:   logger,
+		rates:    make(map[string][]models.ExchangeRate),
+		stale:    make(map[string]time.Time),
+	}
+}
+
+// Currency is the reporting currency.
+func (c *Converter) Currency() string {
+	return c.currency
+}
+
+// Load replaces the known rates with those of the source and marks the
+// payments they convert differently for Backfill. Nothing is known about
+// the rates stored amounts were converted with before the first load, nor
+// about the reporting currency they are in, so that one marks all payments.
+func (c *Converter) Load(ctx context.Context) error {
+	all, err := c.source.Rates(ctx)
+	if err != nil {
+		return err
+	}
+
+	rates := make(map[string][]models.ExchangeRate)
+	for _, r := range all {
+		switch c.currency {
+		case r.Quote:
+		case r.Currency:
+			r.Currency, r.Quote, r.Rate = r.Quote, r.Currency, 1/r.Rate
+		default:
+			continue
+		}
+		rates[r.Currency] = append(rates[r.Currency], r)
+	}
+	for _, rs := range rates {
+		sort.SliceStable(rs, func(i, j int) bool { return rs[i].EffectiveFrom.Before(rs[j].EffectiveFrom) })
+	}
+
+	c.mu.Lock()
+	if c.loaded {
+		for currency, from := range changedRates(c.rates, rates) {
+			c.markStale(currency, from)
+		}
+	} else {
+		c.markStale(allCurrencies, time.Time{})
+	}
+	c.rates, c.loaded = rates, true
+	c.mu.Unlock()
+
+	c.logger.Infow("exchange rates loaded", "currency", c.currency, "currencies", len(rates), "rates", len(all))
+	return nil
+}
+
+func (c *Converter) markStale(currency string, from time.Time) {
+	if t, ok := c.stale[currency]; !ok || from.Before(t) {
+		c.stale[currency] = from
+	}
+}
+
+// changedRates returns, for every currency whose rates differ between old
+// and rates, the time from which conversions with the two differ.
+func changedRates(old, rates map[string][]models.ExchangeRate) map[string]time.Time {
+	out := make(map[string]time.Time)
+	diff := func(currency string) {
+		a, b := old[currency], rates[currency]
+		for i := 0; i < len(a) || i < len(b); i++ {
+			switch {
+			case i >= len(a):
+				out[currency] = b[i].EffectiveFrom
+			case i >= len(b):
+				out[currency] = a[i].EffectiveFrom
+			case !a[i].EffectiveFrom.Equal(b[i].EffectiveFrom):
+				out[currency] = minTime(a[i].EffectiveFrom, b[i].EffectiveFrom)
+			case a[i].Rate != b[i].Rate:
+				out[currency] = a[i].EffectiveFrom
+			default:
+				continue
+			}
+			return
+		}
+	}
+	for currency := range old {
+		diff(currency)
+	}
+	for currency := range rates {
+		if _, ok := old[currency]; !ok {
+			diff(currency)
+		}
+	}
+	return out
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// Convert returns m in the reporting currency at the rate in effect at t,
+// or false if no such rate is known.
+func (c *Converter) Convert(m models.Money, t time.Time) (models.Money, bool) {
+	if m.Currency == c.currency {
+		return m, true
+	}
+
+	c.mu.RLock()
+	rs := c.rates[m.Currency]
+	c.mu.RUnlock()
+
+	i := sort.Search(len(rs), func(i int) bool { return rs[i].EffectiveFrom.After(t) })
+	if i == 0 {
+		return models.Money{}, false
+	}
+	scale := math.Pow10(models.MinorUnits(c.currency) - models.MinorUnits(m.Currency))
+	v := math.Round(float64(m.Amount) * rs[i-1].Rate * scale)
+	if v >= math.MaxInt64 || v <= math.MinInt64 {
+		return models.Money{}, false
+	}
+	return models.Money{Amount: int64(v), Currency: c.currency}, true
+}
+
+// Apply sets the reporting amount of p, clearing it when no rate is known.
+// It reports whether p was converted.
+func (c *Converter) Apply(p *models.Payment) bool {
+	m, ok := c.Convert(p.Amount, time.Unix(p.PaymentDt, 0))
+	p.ReportingAmount, p.ReportingCurrency = m, m.Currency
+	return ok
+}
+
+// Backfill reconverts stored payments marked by Load, then converts those
+// that have no reporting amount yet. Only changed amounts are written, and
+// the cached orders and customer histories showing them are dropped.
+func (c *Converter) Backfill(ctx context.Context) (int64, error) {
+	c.mu.RLock()
+	stale := maps.Clone(c.stale)
+	c.mu.RUnlock()
+
+	var total int64
+	for currency, from := range stale {
+		n, err := c.backfill(ctx, func(after string) ([]models.Payment, error) {
+			return c.store.PaymentsSince(ctx, currency, from, after, backfillBatch)
+		})
+		total += n
+		if err != nil {
+			return total, err
+		}
+		c.mu.Lock()
+		if t, ok := c.stale[currency]; ok && t.Equal(from) {
+			delete(c.stale, currency)
+		}
+		c.mu.Unlock()
+	}
+
+	n, err := c.backfill(ctx, func(after string) ([]models.Payment, error) {
+		return c.store.UnconvertedPayments(ctx, after, backfillBatch)
+	})
+	return total + n, err
+}
+
+// backfill converts the payments returned by next, a page at a time.
+func (c *Converter) backfill(ctx context.Context, next func(after string) ([]models.Payment, error)) (int64, error) {
+	var total int64
+	for after := ""; ; {
+		payments, err := next(after)
+		if err != nil {
+			return total, err
+		}
+		if len(payments) == 0 {
+			return total, nil
+		}
+		after = payments[len(payments)-1].OrderUID
+
+		changed := payments[:0]
+		for _, p := range payments {
+			before, _ := p.Reporting()
+			c.Apply(&p)
+			if after, _ := p.Reporting(); after != before {
+				changed = append(changed, p)
+			}
+		}
+		customers, err := c.store.SetReporting(ctx, changed)
+		total += int64(len(customers))
+		for orderUID, customerID := range customers {
+			c.cache.Remove(orderUID)
+			c.cache.InvalidateCustomer(customerID)
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Run reloads rates and backfills payments right away and then every interval.
+func (c *Converter) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	c.logger.Infow("currency converter started", "interval", interval, "currency", c.currency)
+
+	for {
+		if err := c.Load(ctx); err != nil {
+			c.logger.Errorw("load exchange rates failed", "err", err)
+		} else if n, err := c.Backfill(ctx); err != nil {
+			c.logger.Errorw("reporting amount backfill failed", "converted", n, "err", err)
+		} else if n > 0 {
+			c.logger.Infow("reporting amounts backfilled", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			c.logger.Infow("currency converter stopped")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"wb/internal/generator"
+	"wb/internal/models"
+	"wb/internal/repository"
+)
+
+type staticRates []models.ExchangeRate
+
+func (s *staticRates) Rates(context.Context) ([]models.ExchangeRate, error) {
+	return *s, nil
+}
+
+type removedCache struct {
+	orders, customers map[string]bool
+}
+
+func (c *removedCache) Remove(orderUID string)               { c.orders[orderUID] = true }
+func (c *removedCache) InvalidateCustomer(customerID string) { c.customers[customerID] = true }
+
+func day(d int) time.Time {
+	return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func rate(currency, quote string, from time.Time, r float64) models.ExchangeRate {
+	return models.ExchangeRate{Currency: currency, Quote: quote, EffectiveFrom: from, Rate: r}
+}
+
+func newTestConverter(t *testing.T, rates *staticRates, store ReportingStore) (*Converter, *removedCache) {
+	t.Helper()
+	cache := &removedCache{orders: map[string]bool{}, customers: map[string]bool{}}
+	c := NewConverter(nil, rates, store, cache, "RUB")
+	if err := c.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return c, cache
+}
+
+func TestConverterConvert(t *testing.T) {
+	rates := staticRates{
+		rate("USD", "RUB", day(10), 100),
+		rate("USD", "RUB", day(1), 90),
+		rate("RUB", "KZT", day(1), 5), // quoted the other way round
+		rate("JPY", "RUB", day(1), 0.6),
+		rate("USD", "EUR", day(1), 0.9), // not the reporting currency
+	}
+	c, _ := newTestConverter(t, &rates, nil)
+
+	tests := []struct {
+		name string
+		m    models.Money
+		at   time.Time
+		want models.Money
+		ok   bool
+	}{
+		{"reporting currency", models.Money{Amount: 1817, Currency: "RUB"}, day(1), models.Money{Amount: 1817, Currency: "RUB"}, true},
+		{"first rate", models.Money{Amount: 1000, Currency: "USD"}, day(1), models.Money{Amount: 90000, Currency: "RUB"}, true},
+		{"rate in effect", models.Money{Amount: 1000, Currency: "USD"}, day(9).Add(23 * time.Hour), models.Money{Amount: 90000, Currency: "RUB"}, true},
+		{"later rate", models.Money{Amount: 1000, Currency: "USD"}, day(10), models.Money{Amount: 100000, Currency: "RUB"}, true},
+		{"negative", models.Money{Amount: -1000, Currency: "USD"}, day(10), models.Money{Amount: -100000, Currency: "RUB"}, true},
+		{"inverted rate", models.Money{Amount: 500, Currency: "KZT"}, day(2), models.Money{Amount: 100, Currency: "RUB"}, true},
+		{"no minor units", models.Money{Amount: 1000, Currency: "JPY"}, day(2), models.Money{Amount: 60000, Currency: "RUB"}, true},
+		{"before the first rate", models.Money{Amount: 1000, Currency: "USD"}, day(1).Add(-time.Second), models.Money{}, false},
+		{"unknown currency", models.Money{Amount: 1000, Currency: "EUR"}, day(2), models.Money{}, false},
+		{"overflow", models.Money{Amount: 1 << 62, Currency: "USD"}, day(2), models.Money{}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := c.Convert(tc.m, tc.at)
+			if got != tc.want || ok != tc.ok {
+				t.Fatalf("Convert = %v, %v; want %v, %v", got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestConverterApplyOverwritesClientValue(t *testing.T) {
+	rates := staticRates{rate("USD", "RUB", day(1), 100)}
+	c, _ := newTestConverter(t, &rates, nil)
+
+	client := models.Money{Amount: 1, Currency: "EUR"}
+	p := models.Payment{Currency: "USD", Amount: models.Money{Amount: 1000, Currency: "USD"}, PaymentDt: day(2).Unix(),
+		ReportingAmount: client, ReportingCurrency: "EUR"}
+	if !c.Apply(&p) {
+		t.Fatal("payment was not converted")
+	}
+	if got, _ := p.Reporting(); got != (models.Money{Amount: 100000, Currency: "RUB"}) {
+		t.Fatalf("reporting amount = %v, want 1000.00 RUB", got)
+	}
+
+	p.PaymentDt = day(1).Add(-time.Hour).Unix()
+	p.ReportingAmount, p.ReportingCurrency = client, "EUR"
+	if c.Apply(&p) {
+		t.Fatal("payment before the first rate was converted")
+	}
+	if got, ok := p.Reporting(); ok {
+		t.Fatalf("reporting amount = %v, want none without a rate", got)
+	}
+
+	// Orders written through the service get the converted amount too.
+	repo := repository.NewMemoryOrderRepo()
+	s := NewOrderService(nil, repo, c)
+	o := generator.New(1, day(2)).Order(1)
+	o.Payment.Currency = "USD"
+	o.Payment.Amount = models.Money{Amount: 1000, Currency: "USD"}
+	o.Payment.PaymentDt = day(2).Unix()
+	o.Payment.ReportingAmount, o.Payment.ReportingCurrency = client, "EUR"
+	if _, err := s.UpsertOrder(context.Background(), &o); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := repo.Get(context.Background(), o.OrderUID)
+	if got, _ := stored.Payment.Reporting(); got != (models.Money{Amount: 100000, Currency: "RUB"}) {
+		t.Fatalf("stored reporting amount = %v, want 1000.00 RUB", got)
+	}
+}
+
+func TestChangedRates(t *testing.T) {
+	usd := []models.ExchangeRate{rate("USD", "RUB", day(1), 90), rate("USD", "RUB", day(10), 100)}
+	tests := []struct {
+		name string
+		new  map[string][]models.ExchangeRate
+		want map[string]time.Time
+	}{
+		{"same", map[string][]models.ExchangeRate{"USD": usd}, map[string]time.Time{}},
+		{"rate changed", map[string][]models.ExchangeRate{"USD": {usd[0], rate("USD", "RUB", day(10), 101)}},
+			map[string]time.Time{"USD": day(10)}},
+		{"rate added", map[string][]models.ExchangeRate{"USD": {usd[0], usd[1], rate("USD", "RUB", day(20), 110)}},
+			map[string]time.Time{"USD": day(20)}},
+		{"rate removed", map[string][]models.ExchangeRate{"USD": usd[:1]}, map[string]time.Time{"USD": day(10)}},
+		{"rate moved earlier", map[string][]models.ExchangeRate{"USD": {usd[0], rate("USD", "RUB", day(5), 100)}},
+			map[string]time.Time{"USD": day(5)}},
+		{"currency added", map[string][]models.ExchangeRate{"USD": usd, "KZT": {rate("KZT", "RUB", day(3), 0.2)}},
+			map[string]time.Time{"KZT": day(3)}},
+		{"currency removed", map[string][]models.ExchangeRate{}, map[string]time.Time{"USD": day(1)}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := changedRates(map[string][]models.ExchangeRate{"USD": usd}, tc.new)
+			if len(got) != len(tc.want) {
+				t.Fatalf("changed = %v, want %v", got, tc.want)
+			}
+			for currency, from := range tc.want {
+				if !got[currency].Equal(from) {
+					t.Fatalf("changed = %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestConverterBackfillReconverts(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryOrderRepo()
+	gen := generator.New(1, day(1))
+	write := func(currency string, amount int64, paid time.Time) models.Order {
+		o := gen.Order(1)
+		o.Payment.Currency = currency
+		o.Payment.Amount = models.Money{Amount: amount, Currency: currency}
+		o.Payment.PaymentDt = paid.Unix()
+		if _, err := repo.Upsert(ctx, &o, 0); err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+	early := write("USD", 1000, day(2))
+	late := write("USD", 1000, day(12))
+	kzt := write("KZT", 500, day(12))
+	reporting := func(o models.Order) models.Money {
+		t.Helper()
+		stored, err := repo.Get(ctx, o.OrderUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, _ := stored.Payment.Reporting()
+		return m
+	}
+
+	// Orders were written without rates; the first load converts them all.
+	rates := staticRates{rate("USD", "RUB", day(1), 90), rate("KZT", "RUB", day(1), 0.2)}
+	c, cache := newTestConverter(t, &rates, repo)
+	if n, err := c.Backfill(ctx); err != nil || n != 3 {
+		t.Fatalf("first backfill = %d, %v; want 3", n, err)
+	}
+	if got := reporting(late); got.Amount != 90000 {
+		t.Fatalf("converted amount = %v, want 900.00 RUB", got)
+	}
+	if !cache.orders[late.OrderUID] || !cache.customers[late.CustomerId] {
+		t.Fatal("converted order and its customer were not dropped from the cache")
+	}
+
+	// A new USD rate from day 10 reconverts only the USD payment made since.
+	rates = append(rates, rate("USD", "RUB", day(10), 100))
+	c.cache = &removedCache{orders: map[string]bool{}, customers: map[string]bool{}}
+	if err := c.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Backfill(ctx); err != nil || n != 1 {
+		t.Fatalf("backfill after a rate change = %d, %v; want 1", n, err)
+	}
+	if got := reporting(late); got.Amount != 100000 {
+		t.Fatalf("reconverted amount = %v, want 1000.00 RUB", got)
+	}
+	if got := reporting(early); got.Amount != 90000 {
+		t.Fatalf("amount paid before the change = %v, want 900.00 RUB unchanged", got)
+	}
+	if got := reporting(kzt); got.Amount != 100 {
+		t.Fatalf("KZT amount = %v, want 1.00 RUB unchanged", got)
+	}
+	if removed := c.cache.(*removedCache); len(removed.orders) != 1 || !removed.orders[late.OrderUID] {
+		t.Fatalf("dropped orders = %v, want only %s", removed.orders, late.OrderUID)
+	}
+
+	// Nothing is left to do.
+	if n, err := c.Backfill(ctx); err != nil || n != 0 {
+		t.Fatalf("repeated backfill = %d, %v; want 0", n, err)
+	}
+}
@@ -28,6 +28,13 @@ func (h *SimpleUi) Index(w http.ResponseWriter, r *http.Request) {
   </form>
   <pre id="result"></pre>
 
+  <h2>Поиск по заказам</h2>
+  <form id="searchForm">
+    <input type="text" id="q" placeholder="Имя, адрес, email, товар" />
+    <button type="submit">Найти</button>
+  </form>
+  <ol id="hits"></ol>
+
   <script>
     document.getElementById("orderForm").addEventListener("submit", async function(e) {
       e.preventDefault();
@@ -44,6 +51,53 @@ func (h *SimpleUi) Index(w http.ResponseWriter, r *http.Request) {
       const data = await resp.json();
       document.getElementById("result").innerText = JSON.stringify(data, null, 2);
     });
+
+    function escapeHTML(s) {
+      return s.replace(/[&<>"']/g, c => ({"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;"})[c]);
+    }
+
+    function markup(s) {
+      return escapeHTML(s).replace(/&lt;(\/?)mark&gt;/g, "<$1mark>");
+    }
+
+    document.getElementById("searchForm").addEventListener("submit", async function(e) {
+      e.preventDefault();
+      const q = document.getElementById("q").value.trim();
+      const hits = document.getElementById("hits");
+      hits.innerHTML = "";
+      if (!q) {
+        alert("Введите запрос");
+        return;
+      }
+      const resp = await fetch("/orders/search?q=" + encodeURIComponent(q));
+      if (!resp.ok) {
+        hits.innerText = "Ошибка: " + resp.status;
+        return;
+      }
+      const data = await resp.json();
+      if (data.results.length === 0) {
+        hits.innerText = "Ничего не найдено";
+        return;
+      }
+      for (const hit of data.results) {
+        const li = document.createElement("li");
+        const link = document.createElement("a");
+        link.href = "#";
+        link.innerText = hit.order.order_uid;
+        link.addEventListener("click", function(e) {
+          e.preventDefault();
+          document.getElementById("uid").value = hit.order.order_uid;
+          document.getElementById("orderForm").requestSubmit();
+        });
+        li.appendChild(link);
+        for (const h of hit.highlights || []) {
+          const div = document.createElement("div");
+          div.innerHTML = markup(h);
+          li.appendChild(div);
+        }
+        hits.appendChild(li);
+      }
+    });
   </script>
 </body>
 </html>
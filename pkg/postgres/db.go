@@ -1,47 +1,206 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"embed"
+	"fmt"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/pkg/errors"
 	"github.com/pressly/goose/v3"
 	"go.uber.org/zap"
 	"os"
+	"path/filepath"
+	"regexp"
 )
 
 //go:embed migrations/*.sql
 var migrations embed.FS
 
+const migrationsDir = "migrations"
+
+// InitDB connects to PG_DSN and brings the schema up to date. With
+// PG_MIGRATIONS=verify it only checks that migrations were already applied,
+// for deploys that run them as a separate step.
 func InitDB(logger *zap.SugaredLogger) (*sqlx.DB, error) {
-	dsn := os.Getenv("PG_DSN")
+	db, err := Connect(logger)
+	if err != nil {
+		return nil, err
+	}
 
-	logger.Infof("Connecting to DB with URL: %s", dsn)
+	switch mode := os.Getenv("PG_MIGRATIONS"); mode {
+	case "", "up":
+		err = runMigrations(logger)
+	case "verify":
+		err = verifySchema(logger, db.DB)
+	default:
+		err = errors.Errorf("unknown PG_MIGRATIONS mode %q, want up or verify", mode)
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.WithMessage(err, "migrations failed")
+	}
 
-	db, err := sqlx.Connect("postgres", dsn)
+	return db, nil
+}
+
+// Connect opens PG_DSN with the configured pool settings, without touching the schema.
+func Connect(logger *zap.SugaredLogger) (*sqlx.DB, error) {
+	db, err := open(logger, "primary", os.Getenv("PG_DSN"), loadPoolConfig())
 	if err != nil {
 		return nil, errors.WithMessage(err, "init db failed")
 	}
+	return db, nil
+}
 
-	if err := runMigrations(logger, db.DB, "migrations"); err != nil {
-		return nil, errors.WithMessage(err, "migrations failed")
+// ConnectMaintenance opens a single connection to PG_DSN without a
+// statement timeout, for migrations.
+func ConnectMaintenance(logger *zap.SugaredLogger) (*sqlx.DB, error) {
+	db, err := open(logger, "maintenance", os.Getenv("PG_DSN"), maintenanceConfig())
+	if err != nil {
+		return nil, errors.WithMessage(err, "init maintenance db failed")
 	}
-
 	return db, nil
 }
 
-func runMigrations(logger *zap.SugaredLogger, db *sql.DB, migrationsDir string) error {
+func verifySchema(logger *zap.SugaredLogger, db *sql.DB) error {
+	got, err := VerifySchema(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	if want, _ := LatestVersion(); got > want {
+		logger.Warnw("Schema is newer than this build", "version", got, "expected", want)
+	}
+	return nil
+}
+
+// runMigrations applies migrations over a maintenance connection, so they
+// are not cut off by PG_STATEMENT_TIMEOUT.
+func runMigrations(logger *zap.SugaredLogger) error {
+	db, err := ConnectMaintenance(logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := Migrate(context.Background(), db.DB, "up"); err != nil {
+		return err
+	}
+
+	logger.Info("Migrations applied successfully")
+	return nil
+}
+
+// Migrate runs a goose command (up, down, redo, status, version, ...)
+// against the embedded migrations.
+func Migrate(ctx context.Context, db *sql.DB, command string, args ...string) error {
 	goose.SetBaseFS(migrations)
 
 	if err := goose.SetDialect("postgres"); err != nil {
 		return errors.Wrap(err, "set dialect failed")
 	}
 
-	if err := goose.Up(db, migrationsDir); err != nil {
-		return errors.Wrap(err, "apply migrations failed")
+	if err := goose.RunContext(ctx, command, db, migrationsDir, args...); err != nil {
+		return errors.Wrapf(err, "migrate %s failed", command)
 	}
-
-	logger.Info("Migrations applied successfully")
 	return nil
 }
+
+// LatestVersion is the version of the newest embedded migration.
+func LatestVersion() (int64, error) {
+	goose.SetBaseFS(migrations)
+
+	ms, err := goose.CollectMigrations(migrationsDir, 0, goose.MaxVersion)
+	if err != nil {
+		return 0, errors.Wrap(err, "collect migrations failed")
+	}
+	last, err := ms.Last()
+	if err != nil {
+		return 0, errors.Wrap(err, "no migrations")
+	}
+	return last.Version, nil
+}
+
+// VerifySchema fails unless the database has every embedded migration
+// applied, and returns its version. A newer schema is accepted: migrations
+// only add to the schema, so instances still running the previous release
+// keep working after the next release migrated the database.
+// VerifySchema only reads goose_db_version and never creates it.
+func VerifySchema(ctx context.Context, db *sql.DB) (int64, error) {
+	want, err := LatestVersion()
+	if err != nil {
+		return 0, err
+	}
+	got, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	if got < want {
+		return got, errors.Errorf("schema is at version %d, expected %d: run migrations first", got, want)
+	}
+	return got, nil
+}
+
+// SchemaVersion returns the version of the newest applied migration, 0 if
+// none was applied, reading goose_db_version without modifying anything.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int64, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass('goose_db_version') IS NOT NULL`).Scan(&exists); err != nil {
+		return 0, errors.Wrap(err, "check version table failed")
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	// A version counts as applied if its latest row says so; goose records
+	// a rollback as another row with is_applied = false.
+	const q = `
+		SELECT COALESCE(MAX(version_id), 0)
+		FROM (
+			SELECT DISTINCT ON (version_id) version_id, is_applied
+			FROM goose_db_version
+			ORDER BY version_id, id DESC
+		) v
+		WHERE is_applied
+	`
+	var version int64
+	if err := db.QueryRowContext(ctx, q).Scan(&version); err != nil {
+		return 0, errors.Wrap(err, "get schema version failed")
+	}
+	return version, nil
+}
+
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// CreateMigration writes an empty NN_name.sql migration into dir, numbered
+// after the newest migration found there, and returns its path.
+func CreateMigration(dir, name string) (string, error) {
+	if !migrationName.MatchString(name) {
+		return "", errors.Errorf("bad migration name %q, want lower_snake_case", name)
+	}
+
+	goose.SetBaseFS(nil)
+	defer goose.SetBaseFS(migrations)
+
+	var next int64 = 1
+	ms, err := goose.CollectMigrations(dir, 0, goose.MaxVersion)
+	if err != nil {
+		return "", errors.Wrap(err, "collect migrations failed")
+	}
+	if last, err := ms.Last(); err == nil {
+		next = last.Version + 1
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%02d_%s.sql", next, name))
+	const tmpl = "-- +goose Up\n\n-- +goose Down\n"
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", errors.Wrap(err, "create migration file failed")
+	}
+	if _, err := f.WriteString(tmpl); err != nil {
+		_ = f.Close()
+		return "", errors.Wrap(err, "write migration file failed")
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+// transientClasses are SQLSTATE classes worth retrying: connection
+// exceptions, transaction rollbacks (serialization failures, deadlocks),
+// insufficient resources and operator intervention (shutdowns).
+var transientClasses = map[pq.ErrorClass]bool{
+	"08": true,
+	"40": true,
+	"53": true,
+	"57": true,
+}
+
+// queryCanceled is in class 57 but is mostly a statement_timeout, which the
+// same statement is likely to hit again.
+const queryCanceled = "57014"
+
+// IsTransient reports whether err is likely to go away when the statement is
+// retried, as opposed to errors caused by the data itself.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return transientClasses[pqErr.Code.Class()] && pqErr.Code != queryCanceled
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// IsOutOfRange reports whether err is a numeric_value_out_of_range error,
+// e.g. a SUM that does not fit the column type it is cast to.
+func IsOutOfRange(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "22003"
+}
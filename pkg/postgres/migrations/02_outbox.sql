@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS order_events
+(
+    id           BIGSERIAL PRIMARY KEY,
+    order_uid    TEXT        NOT NULL,
+    event_type   TEXT        NOT NULL,
+    payload      JSONB       NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    published_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_events_unpublished ON order_events (id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_order_events_published_at ON order_events (published_at) WHERE published_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_order_events_published_at;
+DROP INDEX IF EXISTS idx_order_events_unpublished;
+DROP TABLE IF EXISTS order_events;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE INDEX IF NOT EXISTS idx_orders_date_created ON orders (date_created, order_uid);
+CREATE INDEX IF NOT EXISTS idx_orders_customer_id ON orders (customer_id, date_created);
+CREATE INDEX IF NOT EXISTS idx_orders_delivery_service ON orders (delivery_service);
+CREATE INDEX IF NOT EXISTS idx_payments_amount ON payments (amount, order_uid);
+CREATE INDEX IF NOT EXISTS idx_payments_currency ON payments (currency);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_payments_currency;
+DROP INDEX IF EXISTS idx_payments_amount;
+DROP INDEX IF EXISTS idx_orders_delivery_service;
+DROP INDEX IF EXISTS idx_orders_customer_id;
+DROP INDEX IF EXISTS idx_orders_date_created;
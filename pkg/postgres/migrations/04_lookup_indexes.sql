@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE INDEX IF NOT EXISTS idx_items_order_uid ON items (order_uid);
+CREATE INDEX IF NOT EXISTS idx_items_chrt_id ON items (chrt_id);
+CREATE INDEX IF NOT EXISTS idx_items_rid ON items (rid);
+CREATE INDEX IF NOT EXISTS idx_items_nm_id ON items (nm_id);
+CREATE INDEX IF NOT EXISTS idx_orders_track_number ON orders (track_number);
+CREATE INDEX IF NOT EXISTS idx_payments_transaction ON payments (transaction);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_payments_transaction;
+DROP INDEX IF EXISTS idx_orders_track_number;
+DROP INDEX IF EXISTS idx_items_nm_id;
+DROP INDEX IF EXISTS idx_items_rid;
+DROP INDEX IF EXISTS idx_items_chrt_id;
+DROP INDEX IF EXISTS idx_items_order_uid;
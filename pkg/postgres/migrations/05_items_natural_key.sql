@@ -0,0 +1,14 @@
+-- +goose Up
+DELETE FROM items a
+    USING items b
+WHERE a.order_uid = b.order_uid
+  AND a.chrt_id = b.chrt_id
+  AND a.rid = b.rid
+  AND a.id < b.id;
+
+ALTER TABLE items
+    ADD CONSTRAINT items_order_chrt_rid_key UNIQUE (order_uid, chrt_id, rid);
+
+-- +goose Down
+ALTER TABLE items
+    DROP CONSTRAINT IF EXISTS items_order_chrt_rid_key;
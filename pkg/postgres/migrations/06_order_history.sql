@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS order_history
+(
+    id          BIGSERIAL PRIMARY KEY,
+    order_uid   TEXT        NOT NULL,
+    version     INT         NOT NULL,
+    operation   TEXT        NOT NULL,
+    snapshot    JSONB       NOT NULL,
+    source      TEXT        NOT NULL DEFAULT '',
+    actor       TEXT        NOT NULL DEFAULT '',
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (order_uid, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_history_recorded_at ON order_history (order_uid, recorded_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_order_history_recorded_at;
+DROP TABLE IF EXISTS order_history;
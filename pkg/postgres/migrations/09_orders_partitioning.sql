@@ -0,0 +1,123 @@
+-- +goose Up
+ALTER TABLE deliveries DROP CONSTRAINT IF EXISTS deliveries_order_uid_fkey;
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_order_uid_fkey;
+ALTER TABLE items DROP CONSTRAINT IF EXISTS items_order_uid_fkey;
+
+ALTER TABLE orders RENAME TO orders_unpartitioned;
+ALTER TABLE orders_unpartitioned RENAME CONSTRAINT orders_pkey TO orders_unpartitioned_pkey;
+
+CREATE TABLE orders
+(
+    order_uid          TEXT        NOT NULL,
+    track_number       TEXT        NOT NULL,
+    entry              TEXT        NOT NULL,
+    locale             TEXT        NOT NULL,
+    internal_signature TEXT,
+    customer_id        TEXT        NOT NULL,
+    delivery_service   TEXT        NOT NULL,
+    shardkey           TEXT        NOT NULL,
+    sm_id              INT         NOT NULL,
+    date_created       TIMESTAMP   NOT NULL,
+    oof_shard          TEXT        NOT NULL,
+    deleted_at         TIMESTAMPTZ,
+    version            BIGINT      NOT NULL DEFAULT 1,
+    PRIMARY KEY (order_uid, date_created)
+) PARTITION BY RANGE (date_created);
+
+CREATE TABLE orders_default PARTITION OF orders DEFAULT;
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+    m DATE;
+BEGIN
+    FOR m IN SELECT DISTINCT date_trunc('month', date_created)::date FROM orders_unpartitioned
+    LOOP
+        EXECUTE format('CREATE TABLE %I PARTITION OF orders FOR VALUES FROM (%L) TO (%L)',
+                       'orders_' || to_char(m, 'YYYY_MM'), m, (m + INTERVAL '1 month')::date);
+    END LOOP;
+END
+$$;
+-- +goose StatementEnd
+
+INSERT INTO orders (order_uid, track_number, entry, locale, internal_signature, customer_id,
+                    delivery_service, shardkey, sm_id, date_created, oof_shard, deleted_at, version)
+SELECT order_uid, track_number, entry, locale, internal_signature, customer_id,
+       delivery_service, shardkey, sm_id, date_created, oof_shard, deleted_at, version
+FROM orders_unpartitioned;
+
+DROP TABLE orders_unpartitioned;
+
+CREATE INDEX IF NOT EXISTS idx_orders_date_created ON orders (date_created, order_uid);
+CREATE INDEX IF NOT EXISTS idx_orders_customer_id ON orders (customer_id, date_created);
+CREATE INDEX IF NOT EXISTS idx_orders_delivery_service ON orders (delivery_service);
+CREATE INDEX IF NOT EXISTS idx_orders_track_number ON orders (track_number);
+CREATE INDEX IF NOT EXISTS idx_orders_deleted_at ON orders (deleted_at) WHERE deleted_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS order_archives
+(
+    partition_name TEXT PRIMARY KEY,
+    range_from     TIMESTAMP   NOT NULL,
+    range_to       TIMESTAMP   NOT NULL,
+    path           TEXT        NOT NULL,
+    orders         INT         NOT NULL,
+    archived_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS archived_orders
+(
+    order_uid      TEXT PRIMARY KEY,
+    partition_name TEXT NOT NULL REFERENCES order_archives (partition_name) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_archived_orders_partition ON archived_orders (partition_name);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_archived_orders_partition;
+DROP TABLE IF EXISTS archived_orders;
+DROP TABLE IF EXISTS order_archives;
+
+ALTER TABLE orders RENAME TO orders_partitioned;
+ALTER TABLE orders_partitioned RENAME CONSTRAINT orders_pkey TO orders_partitioned_pkey;
+
+CREATE TABLE orders
+(
+    order_uid          TEXT PRIMARY KEY,
+    track_number       TEXT      NOT NULL,
+    entry              TEXT      NOT NULL,
+    locale             TEXT      NOT NULL,
+    internal_signature TEXT,
+    customer_id        TEXT      NOT NULL,
+    delivery_service   TEXT      NOT NULL,
+    shardkey           TEXT      NOT NULL,
+    sm_id              INT       NOT NULL,
+    date_created       TIMESTAMP NOT NULL,
+    oof_shard          TEXT      NOT NULL,
+    deleted_at         TIMESTAMPTZ,
+    version            BIGINT    NOT NULL DEFAULT 1
+);
+
+INSERT INTO orders (order_uid, track_number, entry, locale, internal_signature, customer_id,
+                    delivery_service, shardkey, sm_id, date_created, oof_shard, deleted_at, version)
+SELECT order_uid, track_number, entry, locale, internal_signature, customer_id,
+       delivery_service, shardkey, sm_id, date_created, oof_shard, deleted_at, version
+FROM orders_partitioned;
+
+DROP TABLE orders_partitioned;
+
+CREATE INDEX IF NOT EXISTS idx_orders_date_created ON orders (date_created, order_uid);
+CREATE INDEX IF NOT EXISTS idx_orders_customer_id ON orders (customer_id, date_created);
+CREATE INDEX IF NOT EXISTS idx_orders_delivery_service ON orders (delivery_service);
+CREATE INDEX IF NOT EXISTS idx_orders_track_number ON orders (track_number);
+CREATE INDEX IF NOT EXISTS idx_orders_deleted_at ON orders (deleted_at) WHERE deleted_at IS NOT NULL;
+
+DELETE FROM deliveries WHERE order_uid NOT IN (SELECT order_uid FROM orders);
+DELETE FROM payments WHERE order_uid NOT IN (SELECT order_uid FROM orders);
+DELETE FROM items WHERE order_uid NOT IN (SELECT order_uid FROM orders);
+
+ALTER TABLE deliveries
+    ADD CONSTRAINT deliveries_order_uid_fkey FOREIGN KEY (order_uid) REFERENCES orders (order_uid) ON DELETE CASCADE;
+ALTER TABLE payments
+    ADD CONSTRAINT payments_order_uid_fkey FOREIGN KEY (order_uid) REFERENCES orders (order_uid) ON DELETE CASCADE;
+ALTER TABLE items
+    ADD CONSTRAINT items_order_uid_fkey FOREIGN KEY (order_uid) REFERENCES orders (order_uid) ON DELETE CASCADE;
@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE deliveries
+    ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (
+        to_tsvector('simple', name || ' ' || city || ' ' || region || ' ' || address || ' ' ||
+                              email || ' ' || regexp_replace(email, '[@._+-]+', ' ', 'g'))
+    ) STORED;
+
+ALTER TABLE items
+    ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (
+        to_tsvector('simple', name || ' ' || brand)
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_deliveries_search ON deliveries USING GIN (search);
+CREATE INDEX IF NOT EXISTS idx_items_search ON items USING GIN (search);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_items_search;
+DROP INDEX IF EXISTS idx_deliveries_search;
+ALTER TABLE items
+    DROP COLUMN IF EXISTS search;
+ALTER TABLE deliveries
+    DROP COLUMN IF EXISTS search;
@@ -0,0 +1,30 @@
+-- +goose Up
+-- Amounts are minor units of payments.currency; BIGINT keeps large orders in
+-- currencies with small minor units (IDR, VND, ...) from overflowing INT.
+ALTER TABLE payments
+    ALTER COLUMN amount        TYPE BIGINT,
+    ALTER COLUMN delivery_cost TYPE BIGINT,
+    ALTER COLUMN goods_total   TYPE BIGINT,
+    ALTER COLUMN custom_fee    TYPE BIGINT;
+
+ALTER TABLE items
+    ALTER COLUMN price       TYPE BIGINT,
+    ALTER COLUMN total_price TYPE BIGINT;
+
+-- Existing rows are not checked so the migration cannot fail on old data.
+ALTER TABLE payments
+    ADD CONSTRAINT payments_currency_iso CHECK (currency ~ '^[A-Z]{3}$') NOT VALID;
+
+-- +goose Down
+ALTER TABLE payments
+    DROP CONSTRAINT IF EXISTS payments_currency_iso;
+
+ALTER TABLE items
+    ALTER COLUMN price       TYPE INT,
+    ALTER COLUMN total_price TYPE INT;
+
+ALTER TABLE payments
+    ALTER COLUMN amount        TYPE INT,
+    ALTER COLUMN delivery_cost TYPE INT,
+    ALTER COLUMN goods_total   TYPE INT,
+    ALTER COLUMN custom_fee    TYPE INT;
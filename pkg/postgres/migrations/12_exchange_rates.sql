@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    currency       TEXT           NOT NULL,
+    quote          TEXT           NOT NULL,
+    effective_from TIMESTAMPTZ    NOT NULL,
+    rate           NUMERIC(24, 12) NOT NULL CHECK (rate > 0),
+    PRIMARY KEY (currency, quote, effective_from)
+);
+
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS reporting_amount   BIGINT,
+    ADD COLUMN IF NOT EXISTS reporting_currency TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_payments_unconverted ON payments (order_uid)
+    WHERE reporting_currency IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_payments_unconverted;
+ALTER TABLE payments
+    DROP COLUMN IF EXISTS reporting_currency,
+    DROP COLUMN IF EXISTS reporting_amount;
+DROP TABLE IF EXISTS exchange_rates;
@@ -0,0 +1,29 @@
+-- +goose Up
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'created'
+        CONSTRAINT orders_status_check CHECK (status IN (
+            'created', 'paid', 'assembling', 'shipped', 'delivered', 'cancelled', 'returned'
+        ));
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id          BIGSERIAL   PRIMARY KEY,
+    order_uid   TEXT        NOT NULL,
+    from_status TEXT,
+    status      TEXT        NOT NULL,
+    reason      TEXT        NOT NULL DEFAULT '',
+    changed_at  TIMESTAMPTZ NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    source      TEXT        NOT NULL DEFAULT '',
+    actor       TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order ON order_status_history (order_uid, id);
+
+INSERT INTO order_status_history (order_uid, status, changed_at, source, actor)
+SELECT order_uid, 'created', date_created, 'migration', 'system'
+FROM orders;
+
+-- +goose Down
+DROP TABLE IF EXISTS order_status_history;
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,41 @@
+-- +goose Up
+ALTER TABLE items
+    ADD COLUMN IF NOT EXISTS fulfillment_status TEXT NOT NULL DEFAULT 'created'
+        CONSTRAINT items_fulfillment_status_check CHECK (fulfillment_status IN (
+            'created', 'paid', 'assembling', 'shipped', 'delivered', 'cancelled', 'returned'
+        )),
+    ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+UPDATE items i
+SET fulfillment_status = o.status,
+    status_changed_at  = COALESCE(
+        (SELECT max(h.changed_at) FROM order_status_history h WHERE h.order_uid = o.order_uid),
+        o.date_created
+    )
+FROM orders o
+WHERE o.order_uid = i.order_uid;
+
+CREATE INDEX IF NOT EXISTS idx_items_fulfillment_status ON items (fulfillment_status, order_uid);
+
+CREATE TABLE IF NOT EXISTS item_status_history (
+    id          BIGSERIAL   PRIMARY KEY,
+    order_uid   TEXT        NOT NULL,
+    chrt_id     INT         NOT NULL,
+    rid         TEXT        NOT NULL,
+    from_status TEXT        NOT NULL,
+    status      TEXT        NOT NULL,
+    reason      TEXT        NOT NULL DEFAULT '',
+    changed_at  TIMESTAMPTZ NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    source      TEXT        NOT NULL DEFAULT '',
+    actor       TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_item_status_history_order ON item_status_history (order_uid, id);
+
+-- +goose Down
+DROP TABLE IF EXISTS item_status_history;
+DROP INDEX IF EXISTS idx_items_fulfillment_status;
+ALTER TABLE items
+    DROP COLUMN IF EXISTS status_changed_at,
+    DROP COLUMN IF EXISTS fulfillment_status;
@@ -0,0 +1,69 @@
+-- +goose Up
+-- orders is partitioned by date_created, so deliveries, payments and items
+-- cannot reference it with a foreign key on order_uid alone. These triggers
+-- keep the same invariant: every child row belongs to an order in orders.
+DELETE FROM deliveries d WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_uid = d.order_uid);
+DELETE FROM payments p WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_uid = p.order_uid);
+DELETE FROM items i WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_uid = i.order_uid);
+
+-- Checked at commit, so a transaction may write children before it
+-- attaches the partition holding their order.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION order_child_exists() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM orders WHERE order_uid = NEW.order_uid) THEN
+        RAISE EXCEPTION 'order % of % row does not exist', NEW.order_uid, TG_TABLE_NAME
+            USING ERRCODE = 'foreign_key_violation';
+    END IF;
+    RETURN NULL;
+END
+$$;
+-- +goose StatementEnd
+
+CREATE CONSTRAINT TRIGGER deliveries_order_exists
+    AFTER INSERT OR UPDATE OF order_uid ON deliveries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION order_child_exists();
+CREATE CONSTRAINT TRIGGER payments_order_exists
+    AFTER INSERT OR UPDATE OF order_uid ON payments
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION order_child_exists();
+CREATE CONSTRAINT TRIGGER items_order_exists
+    AFTER INSERT OR UPDATE OF order_uid ON items
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION order_child_exists();
+
+-- Deleting the last row of an order deletes its children. A row moving to
+-- another partition is deleted and re-inserted, so the check runs after the
+-- statement; rows moved out of the default partition into a table that is
+-- attached later in the transaction set wb.moving_orders to skip it.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION order_delete_children() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+BEGIN
+    IF current_setting('wb.moving_orders', true) = 'on'
+        OR EXISTS (SELECT 1 FROM orders WHERE order_uid = OLD.order_uid) THEN
+        RETURN NULL;
+    END IF;
+    DELETE FROM deliveries WHERE order_uid = OLD.order_uid;
+    DELETE FROM payments WHERE order_uid = OLD.order_uid;
+    DELETE FROM items WHERE order_uid = OLD.order_uid;
+    RETURN NULL;
+END
+$$;
+-- +goose StatementEnd
+
+CREATE TRIGGER orders_delete_children
+    AFTER DELETE ON orders
+    FOR EACH ROW EXECUTE FUNCTION order_delete_children();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS orders_delete_children ON orders;
+DROP FUNCTION IF EXISTS order_delete_children();
+DROP TRIGGER IF EXISTS items_order_exists ON items;
+DROP TRIGGER IF EXISTS payments_order_exists ON payments;
+DROP TRIGGER IF EXISTS deliveries_order_exists ON deliveries;
+DROP FUNCTION IF EXISTS order_child_exists();
@@ -0,0 +1,7 @@
+-- +goose Up
+-- Offset of the gzip member holding the order in its archive file; NULL for
+-- archives written before members were indexed.
+ALTER TABLE archived_orders ADD COLUMN IF NOT EXISTS archive_offset BIGINT;
+
+-- +goose Down
+ALTER TABLE archived_orders DROP COLUMN IF EXISTS archive_offset;
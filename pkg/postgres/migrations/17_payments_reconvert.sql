@@ -0,0 +1,8 @@
+-- +goose Up
+-- Backfill reads payments without a reporting amount through
+-- idx_payments_unconverted, whose predicate it repeats, and reconverts the
+-- payments of one currency through this index when its rates change.
+CREATE INDEX IF NOT EXISTS idx_payments_currency_order ON payments (currency, order_uid);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_payments_currency_order;
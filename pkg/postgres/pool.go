@@ -0,0 +1,204 @@
+package postgres
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// poolConfig is read from the environment; zero durations and limits mean
+// "no limit" as in database/sql.
+type poolConfig struct {
+	maxOpen          int
+	maxIdle          int
+	maxLifetime      time.Duration
+	maxIdleTime      time.Duration
+	statementTimeout time.Duration
+	connectTimeout   time.Duration
+	connectWait      time.Duration
+}
+
+func loadPoolConfig() poolConfig {
+	return poolConfig{
+		maxOpen:          envInt("PG_MAX_OPEN_CONNS", 20),
+		maxIdle:          envInt("PG_MAX_IDLE_CONNS", 10),
+		maxLifetime:      envDuration("PG_CONN_MAX_LIFETIME", 30*time.Minute),
+		maxIdleTime:      envDuration("PG_CONN_MAX_IDLE_TIME", 5*time.Minute),
+		statementTimeout: envDuration("PG_STATEMENT_TIMEOUT", 30*time.Second),
+		connectTimeout:   envDuration("PG_CONNECT_TIMEOUT", 5*time.Second),
+		connectWait:      envDuration("PG_CONNECT_WAIT", time.Minute),
+	}
+}
+
+// noStatementTimeout makes withParams set statement_timeout to 0 even if
+// the DSN sets another value.
+const noStatementTimeout time.Duration = -1
+
+// maintenanceConfig is the pool config for migrations: a single connection
+// without a statement timeout, since a migration may rewrite whole tables.
+func maintenanceConfig() poolConfig {
+	cfg := loadPoolConfig()
+	cfg.maxOpen, cfg.maxIdle = 1, 1
+	cfg.statementTimeout = noStatementTimeout
+	return cfg
+}
+
+func envInt(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// open connects to dsn with the pool settings cfg applied, retrying with
+// exponential backoff for up to connectWait while Postgres is starting.
+func open(logger *zap.SugaredLogger, name, dsn string, cfg poolConfig) (*sqlx.DB, error) {
+	dsn = withParams(dsn, cfg)
+
+	logger.Infow("Connecting to DB", "db", name, "dsn", RedactDSN(dsn))
+
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "open %s", name)
+	}
+	db.SetMaxOpenConns(cfg.maxOpen)
+	db.SetMaxIdleConns(cfg.maxIdle)
+	db.SetConnMaxLifetime(cfg.maxLifetime)
+	db.SetConnMaxIdleTime(cfg.maxIdleTime)
+
+	deadline := time.Now().Add(cfg.connectWait)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err = db.Ping()
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			_ = db.Close()
+			return nil, errors.WithMessagef(err, "connect %s after %d attempts", name, attempt)
+		}
+		logger.Warnw("DB not ready, retrying", "db", name, "attempt", attempt, "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, 10*time.Second)
+	}
+}
+
+// withParams adds connect_timeout and statement_timeout to dsn unless it
+// sets them already; noStatementTimeout replaces a statement_timeout set in
+// dsn. lib/pq passes statement_timeout on as a run-time parameter of every
+// connection.
+func withParams(dsn string, cfg poolConfig) string {
+	type param struct {
+		key, value string
+		force      bool
+	}
+	params := []param{}
+	if cfg.connectTimeout > 0 {
+		params = append(params, param{"connect_timeout", strconv.Itoa(max(1, int(cfg.connectTimeout.Seconds()))), false})
+	}
+	if cfg.statementTimeout > 0 {
+		params = append(params, param{"statement_timeout", strconv.FormatInt(cfg.statementTimeout.Milliseconds(), 10), false})
+	}
+	if cfg.statementTimeout == noStatementTimeout {
+		params = append(params, param{"statement_timeout", "0", true})
+	}
+
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		for _, p := range params {
+			if p.force || !q.Has(p.key) {
+				q.Set(p.key, p.value)
+			}
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	for _, p := range params {
+		set := regexp.MustCompile(`\s*\b` + p.key + `\s*=\s*` + dsnValue)
+		if p.force {
+			dsn = set.ReplaceAllString(dsn, "")
+		}
+		if !set.MatchString(dsn) {
+			dsn = strings.TrimSpace(dsn + " " + p.key + "=" + p.value)
+		}
+	}
+	return dsn
+}
+
+// dsnValue matches a value of a key=value DSN, quoted or not.
+const dsnValue = `('(?:[^'\\]|\\.)*'|\S*)`
+
+var dsnPassword = regexp.MustCompile(`(?i)(password\s*=\s*)` + dsnValue)
+
+// RedactDSN hides the password of a URL or key=value DSN so it can be logged.
+func RedactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if q := u.Query(); q.Has("password") {
+			q.Set("password", "xxxxx")
+			u.RawQuery = q.Encode()
+		}
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}xxxxx")
+}
+
+// Monitor pings db and logs its pool stats every interval, warning when the
+// database is unreachable or callers had to wait for a free connection.
+func Monitor(ctx context.Context, logger *zap.SugaredLogger, name string, db *sqlx.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var waited int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := db.PingContext(pingCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			logger.Errorw("DB health check failed", "db", name, "err", err)
+		}
+
+		s := db.Stats()
+		fields := []any{
+			"db", name,
+			"open", s.OpenConnections,
+			"in_use", s.InUse,
+			"idle", s.Idle,
+			"max_open", s.MaxOpenConnections,
+			"wait_count", s.WaitCount,
+			"wait_duration", s.WaitDuration,
+			"max_idle_closed", s.MaxIdleClosed,
+			"max_idle_time_closed", s.MaxIdleTimeClosed,
+			"max_lifetime_closed", s.MaxLifetimeClosed,
+		}
+		if s.WaitCount > waited {
+			logger.Warnw("DB pool exhausted, callers waited for connections", append(fields, "new_waits", s.WaitCount-waited)...)
+		} else {
+			logger.Infow("DB pool stats", fields...)
+		}
+		waited = s.WaitCount
+	}
+}
@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRedactDSN(t *testing.T) {
+	tests := []struct {
+		name, dsn, want string
+	}{
+		{"url", "postgres://app:secret@db:5432/wb?sslmode=disable",
+			"postgres://app:xxxxx@db:5432/wb?sslmode=disable"},
+		{"url without password", "postgres://app@db:5432/wb?sslmode=disable",
+			"postgres://app@db:5432/wb?sslmode=disable"},
+		{"url without user", "postgresql://db/wb", "postgresql://db/wb"},
+		{"url password param", "postgres://app@db/wb?password=secret&sslmode=disable",
+			"postgres://app@db/wb?password=xxxxx&sslmode=disable"},
+		{"key=value", "host=db user=app password=secret dbname=wb",
+			"host=db user=app password=xxxxx dbname=wb"},
+		{"key=value without password", "host=db user=app dbname=wb",
+			"host=db user=app dbname=wb"},
+		{"key = value", "host=db password = secret dbname=wb",
+			"host=db password = xxxxx dbname=wb"},
+		{"quoted", `host=db password='se cr\'et' dbname=wb`,
+			"host=db password=xxxxx dbname=wb"},
+		{"upper case", "host=db PASSWORD=secret", "host=db PASSWORD=xxxxx"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := RedactDSN(tc.dsn); got != tc.want {
+				t.Fatalf("RedactDSN(%q) = %q, want %q", tc.dsn, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithParams(t *testing.T) {
+	cfg := poolConfig{connectTimeout: 5 * time.Second, statementTimeout: 30 * time.Second}
+	maintenance := poolConfig{connectTimeout: 5 * time.Second, statementTimeout: noStatementTimeout}
+
+	tests := []struct {
+		name string
+		dsn  string
+		cfg  poolConfig
+		want string
+	}{
+		{"url", "postgres://app:secret@db/wb?sslmode=disable", cfg,
+			"postgres://app:secret@db/wb?connect_timeout=5&sslmode=disable&statement_timeout=30000"},
+		{"url keeps its values", "postgres://db/wb?statement_timeout=100&connect_timeout=1", cfg,
+			"postgres://db/wb?connect_timeout=1&statement_timeout=100"},
+		{"url without timeout", "postgres://db/wb?statement_timeout=100", maintenance,
+			"postgres://db/wb?connect_timeout=5&statement_timeout=0"},
+		{"url no limits", "postgres://db/wb", poolConfig{}, "postgres://db/wb"},
+		{"sub-second connect timeout", "postgres://db/wb", poolConfig{connectTimeout: time.Millisecond},
+			"postgres://db/wb?connect_timeout=1"},
+		{"key=value", "host=db dbname=wb", cfg,
+			"host=db dbname=wb connect_timeout=5 statement_timeout=30000"},
+		{"key=value keeps its values", "host=db statement_timeout=100 connect_timeout=1", cfg,
+			"host=db statement_timeout=100 connect_timeout=1"},
+		{"key = value keeps its values", "host=db statement_timeout = 100", cfg,
+			"host=db statement_timeout = 100 connect_timeout=5"},
+		{"key=value without timeout", "host=db statement_timeout=100 dbname=wb", maintenance,
+			"host=db dbname=wb connect_timeout=5 statement_timeout=0"},
+		{"key = value without timeout", "statement_timeout = '100' host=db", maintenance,
+			"host=db connect_timeout=5 statement_timeout=0"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := withParams(tc.dsn, tc.cfg); got != tc.want {
+				t.Fatalf("withParams(%q) = %q, want %q", tc.dsn, got, tc.want)
+			}
+		})
+	}
+}
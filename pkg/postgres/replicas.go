@@ -0,0 +1,153 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// InitReplicas connects to the comma separated PG_REPLICA_DSNS. It returns
+// no replicas when the variable is empty.
+func InitReplicas(logger *zap.SugaredLogger) ([]*sqlx.DB, error) {
+	var dbs []*sqlx.DB
+	for i, dsn := range strings.Split(os.Getenv("PG_REPLICA_DSNS"), ",") {
+		dsn = strings.TrimSpace(dsn)
+		if dsn == "" {
+			continue
+		}
+		db, err := open(logger, fmt.Sprintf("replica-%d", len(dbs)), dsn, loadPoolConfig())
+		if err != nil {
+			for _, opened := range dbs {
+				_ = opened.Close()
+			}
+			return nil, errors.WithMessagef(err, "connect replica #%d", i)
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs, nil
+}
+
+type replica struct {
+	name    string
+	db      *sqlx.DB
+	healthy atomic.Bool
+}
+
+// ReplicaSet spreads reads over healthy replicas round-robin and falls back
+// to the primary when none is available.
+type ReplicaSet struct {
+	primary  *sqlx.DB
+	replicas []*replica
+	next     atomic.Uint64
+	maxLag   time.Duration
+	logger   *zap.SugaredLogger
+}
+
+func NewReplicaSet(primary *sqlx.DB, replicas []*sqlx.DB, maxLag time.Duration, logger *zap.SugaredLogger) *ReplicaSet {
+	if logger == nil {
+		logger = zap.NewNop().Sugar()
+	}
+	s := &ReplicaSet{
+		primary: primary,
+		maxLag:  maxLag,
+		logger:  logger,
+	}
+	for i, db := range replicas {
+		rep := &replica{name: fmt.Sprintf("replica-%d", i), db: db}
+		rep.healthy.Store(true)
+		s.replicas = append(s.replicas, rep)
+	}
+	return s
+}
+
+func (s *ReplicaSet) Primary() *sqlx.DB {
+	return s.primary
+}
+
+// MaxLag is the replication lag tolerated before a replica is taken out of rotation.
+func (s *ReplicaSet) MaxLag() time.Duration {
+	return s.maxLag
+}
+
+// Reader returns the next healthy replica or the primary.
+func (s *ReplicaSet) Reader() *sqlx.DB {
+	if s == nil {
+		return nil
+	}
+	n := len(s.replicas)
+	start := int(s.next.Add(1))
+	for i := 0; i < n; i++ {
+		rep := s.replicas[(start+i)%n]
+		if rep.healthy.Load() {
+			return rep.db
+		}
+	}
+	return s.primary
+}
+
+// MarkFailed takes a replica out of rotation until the next successful health check.
+func (s *ReplicaSet) MarkFailed(db *sqlx.DB) {
+	for _, rep := range s.replicas {
+		if rep.db == db && rep.healthy.Swap(false) {
+			s.logger.Warnw("replica marked unhealthy after failed read", "replica", rep.name)
+		}
+	}
+}
+
+// Run checks every replica each interval: it must answer a ping and lag
+// behind the primary by no more than maxLag.
+func (s *ReplicaSet) Run(ctx context.Context, interval time.Duration) error {
+	if len(s.replicas) == 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.logger.Infow("replica health checks started", "replicas", len(s.replicas), "interval", interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			for _, rep := range s.replicas {
+				err := s.check(ctx, rep.db, interval)
+				healthy := err == nil
+				if rep.healthy.Swap(healthy) != healthy {
+					s.logger.Infow("replica health changed", "replica", rep.name, "healthy", healthy, "err", err)
+				}
+			}
+		}
+	}
+}
+
+func (s *ReplicaSet) check(ctx context.Context, db *sqlx.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// A replica that replayed everything it received is not lagging even if
+	// the primary has been idle for a while.
+	const q = `
+		SELECT CASE
+		         WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		         ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+		       END
+	`
+	var lag sql.NullFloat64
+	if err := db.GetContext(ctx, &lag, q); err != nil {
+		return err
+	}
+	if s.maxLag > 0 && lag.Valid && time.Duration(lag.Float64*float64(time.Second)) > s.maxLag {
+		return errors.Errorf("replication lag %.1fs exceeds %s", lag.Float64, s.maxLag)
+	}
+	return nil
+}